@@ -794,6 +794,7 @@ func handleApplicationServiceRegistration(
 	return completeRegistration(
 		req.Context(), userAPI, r.Username, "", appserviceID, req.RemoteAddr, req.UserAgent(), r.Auth.Session,
 		r.InhibitLogin, r.InitialDisplayName, r.DeviceID, userapi.AccountTypeAppService,
+		authtypes.LoginTypeApplicationService,
 	)
 }
 
@@ -813,6 +814,7 @@ func checkAndCompleteFlow(
 		return completeRegistration(
 			req.Context(), userAPI, r.Username, r.Password, "", req.RemoteAddr, req.UserAgent(), sessionID,
 			r.InhibitLogin, r.InitialDisplayName, r.DeviceID, userapi.AccountTypeUser,
+			registrationFlowName(flow),
 		)
 	}
 	sessions.addParams(sessionID, r)
@@ -825,6 +827,17 @@ func checkAndCompleteFlow(
 	}
 }
 
+// registrationFlowName returns the name a completed registration flow is
+// recorded under, which is its stages joined with commas, e.g.
+// "m.login.recaptcha,m.login.dummy".
+func registrationFlowName(flow []authtypes.LoginType) string {
+	stages := make([]string, len(flow))
+	for i, stage := range flow {
+		stages[i] = string(stage)
+	}
+	return strings.Join(stages, ",")
+}
+
 // completeRegistration runs some rudimentary checks against the submitted
 // input, then if successful creates an account and a newly associated device
 // We pass in each individual part of the request here instead of just passing a
@@ -838,6 +851,7 @@ func completeRegistration(
 	inhibitLogin eventutil.WeakBoolean,
 	displayName, deviceID *string,
 	accType userapi.AccountType,
+	registrationFlow string,
 ) util.JSONResponse {
 	var registrationOK bool
 	defer func() {
@@ -861,11 +875,12 @@ func completeRegistration(
 	}
 	var accRes userapi.PerformAccountCreationResponse
 	err := userAPI.PerformAccountCreation(ctx, &userapi.PerformAccountCreationRequest{
-		AppServiceID: appserviceID,
-		Localpart:    username,
-		Password:     password,
-		AccountType:  accType,
-		OnConflict:   userapi.ConflictAbort,
+		AppServiceID:     appserviceID,
+		Localpart:        username,
+		Password:         password,
+		AccountType:      accType,
+		OnConflict:       userapi.ConflictAbort,
+		RegistrationFlow: registrationFlow,
 	}, &accRes)
 	if err != nil {
 		if _, ok := err.(*userapi.ErrorConflict); ok { // user already exists
@@ -1073,5 +1088,5 @@ func handleSharedSecretRegistration(userAPI userapi.UserInternalAPI, sr *SharedS
 	if ssrr.Admin {
 		accType = userapi.AccountTypeAdmin
 	}
-	return completeRegistration(req.Context(), userAPI, ssrr.User, ssrr.Password, "", req.RemoteAddr, req.UserAgent(), "", false, &ssrr.User, &deviceID, accType)
+	return completeRegistration(req.Context(), userAPI, ssrr.User, ssrr.Password, "", req.RemoteAddr, req.UserAgent(), "", false, &ssrr.User, &deviceID, accType, authtypes.LoginTypeSharedSecret)
 }
@@ -129,7 +129,7 @@ func main() {
 		logrus.Fatalln("Username is already in use.")
 	}
 
-	_, err = accountDB.CreateAccount(context.Background(), *username, pass, "", accType)
+	_, err = accountDB.CreateAccount(context.Background(), *username, pass, "", accType, "")
 	if err != nil {
 		logrus.Fatalln("Failed to create the account:", err.Error())
 	}
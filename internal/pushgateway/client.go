@@ -7,11 +7,27 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
+var notifyResponses = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "dendrite",
+		Subsystem: "pushgateway",
+		Name:      "notify_responses_total",
+		Help:      "Total number of push gateway responses, by HTTP status code",
+	},
+	[]string{"code"},
+)
+
+func init() {
+	prometheus.MustRegister(notifyResponses)
+}
+
 type httpClient struct {
 	hc *http.Client
 }
@@ -52,6 +68,8 @@ func (h *httpClient) Notify(ctx context.Context, url string, req *NotifyRequest,
 	//nolint:errcheck
 	defer hresp.Body.Close()
 
+	notifyResponses.WithLabelValues(strconv.Itoa(hresp.StatusCode)).Inc()
+
 	if hresp.StatusCode == http.StatusOK {
 		return json.NewDecoder(hresp.Body).Decode(resp)
 	}
@@ -0,0 +1,58 @@
+package pushgateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNotifyCountsResponseCodes(t *testing.T) {
+	codes := []int{
+		http.StatusOK, http.StatusOK, http.StatusOK,
+		http.StatusNotFound,
+		http.StatusBadGateway, http.StatusBadGateway,
+	}
+	next := make(chan int, len(codes))
+	for _, code := range codes {
+		next <- code
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code := <-next
+		w.WriteHeader(code)
+		if code == http.StatusOK {
+			_, _ = w.Write([]byte(`{"rejected":[]}`))
+		}
+	}))
+	defer srv.Close()
+
+	want := map[int]float64{}
+	for _, code := range codes {
+		want[code]++
+	}
+	before := map[int]float64{}
+	for code := range want {
+		before[code] = testutil.ToFloat64(notifyResponses.WithLabelValues(strconv.Itoa(code)))
+	}
+
+	client := NewHTTPClient(true)
+	for _, code := range codes {
+		err := client.Notify(context.Background(), srv.URL, &NotifyRequest{}, &NotifyResponse{})
+		if code == http.StatusOK && err != nil {
+			t.Fatalf("Notify returned %s", err)
+		}
+		if code != http.StatusOK && err == nil {
+			t.Fatalf("expected Notify to fail for status %d", code)
+		}
+	}
+
+	for code, count := range want {
+		got := testutil.ToFloat64(notifyResponses.WithLabelValues(strconv.Itoa(code))) - before[code]
+		if got != count {
+			t.Errorf("expected %v responses with status %d, got %v", count, code, got)
+		}
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"database/sql"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/matrix-org/dendrite/keyserver/api"
 	"github.com/matrix-org/dendrite/keyserver/types"
@@ -29,6 +30,10 @@ import (
 	"golang.org/x/crypto/curve25519"
 )
 
+// MasterKeyChangesRetention is how long entries are kept in the master key
+// change log before being pruned.
+const MasterKeyChangesRetention = time.Hour * 24 * 30
+
 func sanityCheckKey(key gomatrixserverlib.CrossSigningKey, userID string, purpose gomatrixserverlib.CrossSigningKeyPurpose) error {
 	// Is there exactly one key?
 	if len(key.Keys) != 1 {
@@ -223,6 +228,17 @@ func (a *KeyInternalAPI) PerformUploadDeviceKeys(ctx context.Context, req *api.P
 		return
 	}
 
+	// Replacing an existing master key with a different one resets
+	// cross-signing for the user, so log it for the reset statistics. Failing
+	// to log it doesn't fail the request.
+	if oldMasterKey, ok := existingKeys[gomatrixserverlib.CrossSigningKeyPurposeMaster]; ok {
+		if newMasterKey, ok := toStore[gomatrixserverlib.CrossSigningKeyPurposeMaster]; ok && !bytes.Equal(oldMasterKey, newMasterKey) {
+			if err := a.DB.StoreMasterKeyChange(ctx, req.UserID); err != nil {
+				logrus.WithError(err).Warn("Failed to record master key change")
+			}
+		}
+	}
+
 	// Now upload any signatures that were included with the keys.
 	for _, key := range byPurpose {
 		var targetKeyID gomatrixserverlib.KeyID
@@ -0,0 +1,61 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/dendrite/keyserver/api"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+func TestPerformUploadDeviceKeysCountsResets(t *testing.T) {
+	a := mustCreateKeyInternalAPI(t)
+
+	upload := func(userID string, keyByte byte) {
+		t.Helper()
+		keyData := gomatrixserverlib.Base64Bytes(bytes.Repeat([]byte{keyByte}, ed25519.PublicKeySize))
+		req := &api.PerformUploadDeviceKeysRequest{UserID: userID}
+		req.MasterKey = gomatrixserverlib.CrossSigningKey{
+			UserID: userID,
+			Usage:  []gomatrixserverlib.CrossSigningKeyPurpose{gomatrixserverlib.CrossSigningKeyPurposeMaster},
+			Keys: map[gomatrixserverlib.KeyID]gomatrixserverlib.Base64Bytes{
+				gomatrixserverlib.KeyID("ed25519:" + keyData.Encode()): keyData,
+			},
+		}
+		res := &api.PerformUploadDeviceKeysResponse{}
+		a.PerformUploadDeviceKeys(ctx, req, res)
+		if res.Error != nil {
+			t.Fatalf("PerformUploadDeviceKeys returned %s", res.Error)
+		}
+	}
+	// setting up cross-signing and uploading the same master key again
+	// aren't resets, replacing the master key with a different one is
+	upload("@alice:localhost", 1)
+	upload("@alice:localhost", 1)
+	upload("@alice:localhost", 2)
+	upload("@bob:localhost", 3)
+
+	count, err := a.DB.CrossSigningResetCount(ctx, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("CrossSigningResetCount returned %s", err)
+	}
+	if count != 1 {
+		t.Fatalf("expected 1 cross-signing reset, got %d", count)
+	}
+}
@@ -649,6 +649,15 @@ func (a *KeyInternalAPI) uploadLocalDeviceKeys(ctx context.Context, req *api.Per
 		}
 		return
 	}
+	if !req.OnlyDisplayNameUpdates {
+		uploaded := 0
+		for _, key := range keysToStore {
+			if len(key.KeyJSON) > 0 {
+				uploaded++
+			}
+		}
+		a.recordKeyUpload(ctx, req.UserID, keyUploadTypeDeviceKeys, uploaded)
+	}
 	err = emitDeviceKeyChanges(a.Producer, existingKeys, keysToStore, req.OnlyDisplayNameUpdates)
 	if err != nil {
 		util.GetLogger(ctx).Errorf("Failed to emitDeviceKeyChanges: %s", err)
@@ -705,6 +714,7 @@ func (a *KeyInternalAPI) uploadOneTimeKeys(ctx context.Context, req *api.Perform
 			})
 			continue
 		}
+		a.recordKeyUpload(ctx, req.UserID, keyUploadTypeOneTimeKeys, len(key.KeyJSON))
 		// collect counts
 		res.OneTimeKeyCounts = append(res.OneTimeKeyCounts, *counts)
 	}
@@ -0,0 +1,60 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"time"
+
+	"github.com/matrix-org/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	keyUploadTypeDeviceKeys  = "device_keys"
+	keyUploadTypeOneTimeKeys = "one_time_keys"
+)
+
+// KeyUploadsRetention is how long entries are kept in the key upload log
+// before being pruned.
+const KeyUploadsRetention = time.Hour * 24 * 7
+
+var keyUploadsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "dendrite",
+		Subsystem: "keyserver",
+		Name:      "key_uploads_total",
+		Help:      "Total number of device keys and one-time keys uploaded by local users",
+	},
+	[]string{"type"},
+)
+
+func init() {
+	prometheus.MustRegister(
+		keyUploadsTotal,
+	)
+}
+
+// recordKeyUpload counts an upload of keys by a user, both in the metrics and
+// in the key upload log. Failing to log the upload doesn't fail the request.
+func (a *KeyInternalAPI) recordKeyUpload(ctx context.Context, userID, keyType string, count int) {
+	if count == 0 {
+		return
+	}
+	keyUploadsTotal.WithLabelValues(keyType).Add(float64(count))
+	if err := a.DB.StoreKeyUpload(ctx, userID, keyType, count); err != nil {
+		util.GetLogger(ctx).WithError(err).Warn("Failed to record key upload")
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/dendrite/keyserver/api"
+	"github.com/matrix-org/dendrite/keyserver/producers"
+	"github.com/matrix-org/dendrite/keyserver/storage"
+	"github.com/matrix-org/dendrite/keyserver/types"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/setup/jetstream"
+	"github.com/matrix-org/dendrite/setup/process"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var js nats.JetStreamContext
+
+func TestMain(m *testing.M) {
+	var pc *process.ProcessContext
+	pc, js, _ = jetstream.PrepareForTests()
+	code := m.Run()
+	pc.ShutdownDendrite()
+	pc.WaitForComponentsToFinish()
+	os.Exit(code)
+}
+
+// mockDevicesUserAPI reports that every user exists with the given devices.
+type mockDevicesUserAPI struct {
+	userapi.UserInternalAPI
+	deviceIDs []string
+}
+
+func (u *mockDevicesUserAPI) QueryDevices(ctx context.Context, req *userapi.QueryDevicesRequest, res *userapi.QueryDevicesResponse) error {
+	res.UserExists = true
+	for _, deviceID := range u.deviceIDs {
+		res.Devices = append(res.Devices, userapi.Device{ID: deviceID, UserID: req.UserID})
+	}
+	return nil
+}
+
+// mustCreateKeyInternalAPI creates a keyserver API with a real database and
+// key change producer, for users with the given devices.
+func mustCreateKeyInternalAPI(t *testing.T, deviceIDs ...string) *KeyInternalAPI {
+	t.Helper()
+	cfg := &config.Dendrite{}
+	cfg.Defaults(true)
+
+	db, err := storage.NewDatabase(&config.DatabaseOptions{
+		ConnectionString: config.DataSource("file:" + filepath.Join(t.TempDir(), "keyserver.db")),
+	})
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err)
+	}
+	return &KeyInternalAPI{
+		DB:         db,
+		ThisServer: "localhost",
+		UserAPI:    &mockDevicesUserAPI{deviceIDs: deviceIDs},
+		Producer: &producers.KeyChange{
+			Topic:     cfg.Global.JetStream.Prefixed(jetstream.OutputKeyChangeEvent),
+			JetStream: js,
+			DB:        db,
+		},
+	}
+}
+
+func TestPerformUploadKeysCountsUploads(t *testing.T) {
+	a := mustCreateKeyInternalAPI(t, "ALICE1", "ALICE2", "BOB1")
+
+	deviceKeysBefore := testutil.ToFloat64(keyUploadsTotal.WithLabelValues(keyUploadTypeDeviceKeys))
+	oneTimeKeysBefore := testutil.ToFloat64(keyUploadsTotal.WithLabelValues(keyUploadTypeOneTimeKeys))
+
+	upload := func(userID, deviceID string, oneTimeKeys int) {
+		t.Helper()
+		req := &api.PerformUploadKeysRequest{
+			UserID:   userID,
+			DeviceID: deviceID,
+			DeviceKeys: []api.DeviceKeys{{
+				UserID:   userID,
+				DeviceID: deviceID,
+				KeyJSON:  []byte(fmt.Sprintf(`{"user_id":%q,"device_id":%q}`, userID, deviceID)),
+			}},
+		}
+		if oneTimeKeys > 0 {
+			keys := api.OneTimeKeys{UserID: userID, DeviceID: deviceID, KeyJSON: map[string]json.RawMessage{}}
+			for i := 0; i < oneTimeKeys; i++ {
+				keys.KeyJSON[fmt.Sprintf("signed_curve25519:%s%d", deviceID, i)] = json.RawMessage(`{"key":"foo"}`)
+			}
+			req.OneTimeKeys = []api.OneTimeKeys{keys}
+		}
+		res := &api.PerformUploadKeysResponse{}
+		a.PerformUploadKeys(ctx, req, res)
+		if res.Error != nil {
+			t.Fatalf("PerformUploadKeys returned %s", res.Error)
+		}
+		if len(res.KeyErrors) > 0 {
+			t.Fatalf("PerformUploadKeys returned key errors: %+v", res.KeyErrors)
+		}
+	}
+	upload("@alice:localhost", "ALICE1", 5)
+	upload("@alice:localhost", "ALICE2", 0)
+	upload("@bob:localhost", "BOB1", 2)
+
+	if got := testutil.ToFloat64(keyUploadsTotal.WithLabelValues(keyUploadTypeDeviceKeys)) - deviceKeysBefore; got != 3 {
+		t.Errorf("expected 3 device key uploads to be counted, got %v", got)
+	}
+	if got := testutil.ToFloat64(keyUploadsTotal.WithLabelValues(keyUploadTypeOneTimeKeys)) - oneTimeKeysBefore; got != 7 {
+		t.Errorf("expected 7 one-time key uploads to be counted, got %v", got)
+	}
+
+	top, err := a.DB.TopKeyUploaders(ctx, time.Now().Add(-time.Hour), 10)
+	if err != nil {
+		t.Fatalf("TopKeyUploaders returned %s", err)
+	}
+	// alice uploaded two device keys and five one-time keys, bob uploaded
+	// one device key and two one-time keys
+	want := []types.KeyUploadCount{
+		{UserID: "@alice:localhost", Count: 7},
+		{UserID: "@bob:localhost", Count: 3},
+	}
+	if !reflect.DeepEqual(top, want) {
+		t.Fatalf("TopKeyUploaders: got %+v want %+v", top, want)
+	}
+}
@@ -15,6 +15,8 @@
 package keyserver
 
 import (
+	"time"
+
 	"github.com/gorilla/mux"
 	fedsenderapi "github.com/matrix-org/dendrite/federationapi/api"
 	"github.com/matrix-org/dendrite/keyserver/api"
@@ -64,5 +66,26 @@ func NewInternalAPI(
 		}
 	}()
 
+	// stop cleaning up once the process is shutting down, as the database
+	// may already be closed
+	ctx := base.Context()
+	var cleanOldKeyLogs func()
+	cleanOldKeyLogs = func() {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := db.DeleteKeyUploadsBefore(ctx, time.Now().Add(-internal.KeyUploadsRetention)); err != nil {
+			logrus.WithError(err).Error("Failed to clean old key uploads")
+		}
+		if err := db.DeleteMasterKeyChangesBefore(ctx, time.Now().Add(-internal.MasterKeyChangesRetention)); err != nil {
+			logrus.WithError(err).Error("Failed to clean old master key changes")
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		time.AfterFunc(time.Hour, cleanOldKeyLogs)
+	}
+	time.AfterFunc(time.Minute, cleanOldKeyLogs)
+
 	return ap
 }
@@ -17,6 +17,7 @@ package storage
 import (
 	"context"
 	"encoding/json"
+	"time"
 
 	"github.com/matrix-org/dendrite/keyserver/api"
 	"github.com/matrix-org/dendrite/keyserver/types"
@@ -85,4 +86,19 @@ type Database interface {
 
 	StoreCrossSigningKeysForUser(ctx context.Context, userID string, keyMap types.CrossSigningKeyMap) error
 	StoreCrossSigningSigsForTarget(ctx context.Context, originUserID string, originKeyID gomatrixserverlib.KeyID, targetUserID string, targetKeyID gomatrixserverlib.KeyID, signature gomatrixserverlib.Base64Bytes) error
+
+	// StoreKeyUpload records that a user uploaded `count` keys of the given type ("device_keys" or "one_time_keys").
+	StoreKeyUpload(ctx context.Context, userID, keyType string, count int) error
+	// TopKeyUploaders returns up to `limit` users who uploaded the most keys since the given time, most keys first.
+	TopKeyUploaders(ctx context.Context, since time.Time, limit int) ([]types.KeyUploadCount, error)
+	// DeleteKeyUploadsBefore removes key upload records older than the given time.
+	DeleteKeyUploadsBefore(ctx context.Context, before time.Time) error
+
+	// StoreMasterKeyChange records that a user replaced their cross-signing master key with a different one.
+	StoreMasterKeyChange(ctx context.Context, userID string) error
+	// CrossSigningResetCount returns the number of times users replaced their cross-signing master key in the
+	// window [from, to).
+	CrossSigningResetCount(ctx context.Context, from, to time.Time) (int64, error)
+	// DeleteMasterKeyChangesBefore removes master key change records older than the given time.
+	DeleteMasterKeyChangesBefore(ctx context.Context, before time.Time) error
 }
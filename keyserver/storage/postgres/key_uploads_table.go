@@ -0,0 +1,104 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/matrix-org/dendrite/internal"
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/keyserver/storage/tables"
+	"github.com/matrix-org/dendrite/keyserver/types"
+)
+
+var keyUploadsSchema = `
+-- Stores a log of device key and one-time key uploads by local users, used
+-- to spot users uploading unusual amounts of keys. Old rows are pruned.
+CREATE TABLE IF NOT EXISTS keyserver_key_uploads (
+	user_id TEXT NOT NULL,
+	-- Either "device_keys" or "one_time_keys"
+	key_type TEXT NOT NULL,
+	-- The number of keys uploaded
+	key_count BIGINT NOT NULL,
+	ts_added_secs BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS keyserver_key_uploads_ts_idx ON keyserver_key_uploads (ts_added_secs);
+`
+
+const insertKeyUploadSQL = "" +
+	"INSERT INTO keyserver_key_uploads (user_id, key_type, key_count, ts_added_secs)" +
+	" VALUES ($1, $2, $3, $4)"
+
+const selectTopKeyUploadersSQL = "" +
+	"SELECT user_id, SUM(key_count) AS total FROM keyserver_key_uploads" +
+	" WHERE ts_added_secs >= $1" +
+	" GROUP BY user_id ORDER BY total DESC, user_id ASC LIMIT $2"
+
+const deleteKeyUploadsBeforeSQL = "" +
+	"DELETE FROM keyserver_key_uploads WHERE ts_added_secs < $1"
+
+type keyUploadsStatements struct {
+	insertKeyUploadStmt        *sql.Stmt
+	selectTopKeyUploadersStmt  *sql.Stmt
+	deleteKeyUploadsBeforeStmt *sql.Stmt
+}
+
+func NewPostgresKeyUploadsTable(db *sql.DB) (tables.KeyUploads, error) {
+	s := &keyUploadsStatements{}
+	_, err := db.Exec(keyUploadsSchema)
+	if err != nil {
+		return nil, err
+	}
+	return s, sqlutil.StatementList{
+		{&s.insertKeyUploadStmt, insertKeyUploadSQL},
+		{&s.selectTopKeyUploadersStmt, selectTopKeyUploadersSQL},
+		{&s.deleteKeyUploadsBeforeStmt, deleteKeyUploadsBeforeSQL},
+	}.Prepare(db)
+}
+
+func (s *keyUploadsStatements) InsertKeyUpload(
+	ctx context.Context, txn *sql.Tx, userID, keyType string, count int, tsAddedSecs int64,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.insertKeyUploadStmt).ExecContext(ctx, userID, keyType, count, tsAddedSecs)
+	return err
+}
+
+func (s *keyUploadsStatements) SelectTopKeyUploaders(
+	ctx context.Context, txn *sql.Tx, sinceSecs int64, limit int,
+) ([]types.KeyUploadCount, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectTopKeyUploadersStmt).QueryContext(ctx, sinceSecs, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectTopKeyUploaders: rows.close() failed")
+	var result []types.KeyUploadCount
+	for rows.Next() {
+		var upload types.KeyUploadCount
+		if err = rows.Scan(&upload.UserID, &upload.Count); err != nil {
+			return nil, err
+		}
+		result = append(result, upload)
+	}
+	return result, rows.Err()
+}
+
+func (s *keyUploadsStatements) DeleteKeyUploadsBefore(
+	ctx context.Context, txn *sql.Tx, beforeSecs int64,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.deleteKeyUploadsBeforeStmt).ExecContext(ctx, beforeSecs)
+	return err
+}
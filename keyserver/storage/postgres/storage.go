@@ -52,6 +52,14 @@ func NewDatabase(dbProperties *config.DatabaseOptions) (*shared.Database, error)
 	if err != nil {
 		return nil, err
 	}
+	ku, err := NewPostgresKeyUploadsTable(db)
+	if err != nil {
+		return nil, err
+	}
+	mkc, err := NewPostgresMasterKeyChangesTable(db)
+	if err != nil {
+		return nil, err
+	}
 	m := sqlutil.NewMigrations()
 	deltas.LoadRefactorKeyChanges(m)
 	if err = m.RunDeltas(db, dbProperties); err != nil {
@@ -69,6 +77,8 @@ func NewDatabase(dbProperties *config.DatabaseOptions) (*shared.Database, error)
 		StaleDeviceListsTable: sdl,
 		CrossSigningKeysTable: csk,
 		CrossSigningSigsTable: css,
+		KeyUploadsTable:       ku,
+		MasterKeyChangesTable: mkc,
 	}
 	return d, nil
 }
@@ -19,6 +19,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/matrix-org/dendrite/internal/sqlutil"
 	"github.com/matrix-org/dendrite/keyserver/api"
@@ -36,6 +37,8 @@ type Database struct {
 	StaleDeviceListsTable tables.StaleDeviceLists
 	CrossSigningKeysTable tables.CrossSigningKeys
 	CrossSigningSigsTable tables.CrossSigningSigs
+	KeyUploadsTable       tables.KeyUploads
+	MasterKeyChangesTable tables.MasterKeyChanges
 }
 
 func (d *Database) ExistingOneTimeKeys(ctx context.Context, userID, deviceID string, keyIDsWithAlgorithms []string) (map[string]json.RawMessage, error) {
@@ -249,3 +252,41 @@ func (d *Database) StoreCrossSigningSigsForTarget(
 		return nil
 	})
 }
+
+// StoreKeyUpload records that a user uploaded some number of keys of the given type.
+func (d *Database) StoreKeyUpload(ctx context.Context, userID, keyType string, count int) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.KeyUploadsTable.InsertKeyUpload(ctx, txn, userID, keyType, count, time.Now().Unix())
+	})
+}
+
+// TopKeyUploaders returns up to limit users who uploaded the most keys since the given time.
+func (d *Database) TopKeyUploaders(ctx context.Context, since time.Time, limit int) ([]types.KeyUploadCount, error) {
+	return d.KeyUploadsTable.SelectTopKeyUploaders(ctx, nil, since.Unix(), limit)
+}
+
+// DeleteKeyUploadsBefore prunes the key upload log of entries older than the given time.
+func (d *Database) DeleteKeyUploadsBefore(ctx context.Context, before time.Time) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.KeyUploadsTable.DeleteKeyUploadsBefore(ctx, txn, before.Unix())
+	})
+}
+
+// StoreMasterKeyChange records that a user replaced their cross-signing master key.
+func (d *Database) StoreMasterKeyChange(ctx context.Context, userID string) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.MasterKeyChangesTable.InsertMasterKeyChange(ctx, txn, userID, time.Now().Unix())
+	})
+}
+
+// CrossSigningResetCount returns the number of master key changes in the window [from, to).
+func (d *Database) CrossSigningResetCount(ctx context.Context, from, to time.Time) (int64, error) {
+	return d.MasterKeyChangesTable.SelectMasterKeyChangeCount(ctx, nil, from.Unix(), to.Unix())
+}
+
+// DeleteMasterKeyChangesBefore prunes the master key change log of entries older than the given time.
+func (d *Database) DeleteMasterKeyChangesBefore(ctx context.Context, before time.Time) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.MasterKeyChangesTable.DeleteMasterKeyChangesBefore(ctx, txn, before.Unix())
+	})
+}
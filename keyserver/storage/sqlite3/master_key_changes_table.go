@@ -0,0 +1,84 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/keyserver/storage/tables"
+)
+
+var masterKeyChangesSchema = `
+-- Stores a log of local users replacing their cross-signing master key with
+-- a different one, i.e. resetting cross-signing. Old rows are pruned.
+CREATE TABLE IF NOT EXISTS keyserver_master_key_changes (
+	user_id TEXT NOT NULL,
+	ts_added_secs BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS keyserver_master_key_changes_ts_idx ON keyserver_master_key_changes (ts_added_secs);
+`
+
+const insertMasterKeyChangeSQL = "" +
+	"INSERT INTO keyserver_master_key_changes (user_id, ts_added_secs) VALUES ($1, $2)"
+
+const selectMasterKeyChangeCountSQL = "" +
+	"SELECT COUNT(*) FROM keyserver_master_key_changes" +
+	" WHERE ts_added_secs >= $1 AND ts_added_secs < $2"
+
+const deleteMasterKeyChangesBeforeSQL = "" +
+	"DELETE FROM keyserver_master_key_changes WHERE ts_added_secs < $1"
+
+type masterKeyChangesStatements struct {
+	insertMasterKeyChangeStmt        *sql.Stmt
+	selectMasterKeyChangeCountStmt   *sql.Stmt
+	deleteMasterKeyChangesBeforeStmt *sql.Stmt
+}
+
+func NewSqliteMasterKeyChangesTable(db *sql.DB) (tables.MasterKeyChanges, error) {
+	s := &masterKeyChangesStatements{}
+	_, err := db.Exec(masterKeyChangesSchema)
+	if err != nil {
+		return nil, err
+	}
+	return s, sqlutil.StatementList{
+		{&s.insertMasterKeyChangeStmt, insertMasterKeyChangeSQL},
+		{&s.selectMasterKeyChangeCountStmt, selectMasterKeyChangeCountSQL},
+		{&s.deleteMasterKeyChangesBeforeStmt, deleteMasterKeyChangesBeforeSQL},
+	}.Prepare(db)
+}
+
+func (s *masterKeyChangesStatements) InsertMasterKeyChange(
+	ctx context.Context, txn *sql.Tx, userID string, tsAddedSecs int64,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.insertMasterKeyChangeStmt).ExecContext(ctx, userID, tsAddedSecs)
+	return err
+}
+
+func (s *masterKeyChangesStatements) SelectMasterKeyChangeCount(
+	ctx context.Context, txn *sql.Tx, fromSecs, toSecs int64,
+) (count int64, err error) {
+	err = sqlutil.TxStmt(txn, s.selectMasterKeyChangeCountStmt).QueryRowContext(ctx, fromSecs, toSecs).Scan(&count)
+	return
+}
+
+func (s *masterKeyChangesStatements) DeleteMasterKeyChangesBefore(
+	ctx context.Context, txn *sql.Tx, beforeSecs int64,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.deleteMasterKeyChangesBeforeStmt).ExecContext(ctx, beforeSecs)
+	return err
+}
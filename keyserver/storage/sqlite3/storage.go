@@ -50,6 +50,14 @@ func NewDatabase(dbProperties *config.DatabaseOptions) (*shared.Database, error)
 	if err != nil {
 		return nil, err
 	}
+	ku, err := NewSqliteKeyUploadsTable(db)
+	if err != nil {
+		return nil, err
+	}
+	mkc, err := NewSqliteMasterKeyChangesTable(db)
+	if err != nil {
+		return nil, err
+	}
 
 	m := sqlutil.NewMigrations()
 	deltas.LoadRefactorKeyChanges(m)
@@ -68,6 +76,8 @@ func NewDatabase(dbProperties *config.DatabaseOptions) (*shared.Database, error)
 		StaleDeviceListsTable: sdl,
 		CrossSigningKeysTable: csk,
 		CrossSigningSigsTable: css,
+		KeyUploadsTable:       ku,
+		MasterKeyChangesTable: mkc,
 	}
 	return d, nil
 }
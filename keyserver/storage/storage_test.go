@@ -8,6 +8,7 @@ import (
 	"os"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/matrix-org/dendrite/keyserver/api"
 	"github.com/matrix-org/dendrite/keyserver/types"
@@ -190,3 +191,65 @@ func TestDeviceKeysStreamIDGeneration(t *testing.T) {
 		}
 	}
 }
+
+func TestKeyUploads(t *testing.T) {
+	db, clean := MustCreateDatabase(t)
+	defer clean()
+	alice := "@alice:localhost"
+	bob := "@bob:localhost"
+	charlie := "@charlie:localhost"
+	MustNotError(t, db.StoreKeyUpload(ctx, alice, "one_time_keys", 10))
+	MustNotError(t, db.StoreKeyUpload(ctx, alice, "device_keys", 1))
+	MustNotError(t, db.StoreKeyUpload(ctx, bob, "one_time_keys", 5))
+	MustNotError(t, db.StoreKeyUpload(ctx, charlie, "one_time_keys", 3))
+
+	top, err := db.TopKeyUploaders(ctx, time.Now().Add(-time.Hour), 2)
+	MustNotError(t, err)
+	want := []types.KeyUploadCount{
+		{UserID: alice, Count: 11},
+		{UserID: bob, Count: 5},
+	}
+	if !reflect.DeepEqual(top, want) {
+		t.Fatalf("TopKeyUploaders: got %+v want %+v", top, want)
+	}
+
+	top, err = db.TopKeyUploaders(ctx, time.Now().Add(time.Hour), 2)
+	MustNotError(t, err)
+	if len(top) != 0 {
+		t.Fatalf("TopKeyUploaders: expected no uploads in the future, got %+v", top)
+	}
+
+	MustNotError(t, db.DeleteKeyUploadsBefore(ctx, time.Now().Add(time.Minute)))
+	top, err = db.TopKeyUploaders(ctx, time.Time{}, 10)
+	MustNotError(t, err)
+	if len(top) != 0 {
+		t.Fatalf("TopKeyUploaders: expected pruned uploads to be gone, got %+v", top)
+	}
+}
+
+func TestCrossSigningResetCount(t *testing.T) {
+	db, clean := MustCreateDatabase(t)
+	defer clean()
+	MustNotError(t, db.StoreMasterKeyChange(ctx, "@alice:localhost"))
+	MustNotError(t, db.StoreMasterKeyChange(ctx, "@alice:localhost"))
+	MustNotError(t, db.StoreMasterKeyChange(ctx, "@bob:localhost"))
+
+	count, err := db.CrossSigningResetCount(ctx, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	MustNotError(t, err)
+	if count != 3 {
+		t.Fatalf("CrossSigningResetCount: got %d want 3", count)
+	}
+
+	count, err = db.CrossSigningResetCount(ctx, time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour))
+	MustNotError(t, err)
+	if count != 0 {
+		t.Fatalf("CrossSigningResetCount: expected no resets before the window, got %d", count)
+	}
+
+	MustNotError(t, db.DeleteMasterKeyChangesBefore(ctx, time.Now().Add(time.Minute)))
+	count, err = db.CrossSigningResetCount(ctx, time.Time{}, time.Now().Add(time.Hour))
+	MustNotError(t, err)
+	if count != 0 {
+		t.Fatalf("CrossSigningResetCount: expected pruned resets to be gone, got %d", count)
+	}
+}
@@ -58,6 +58,20 @@ type StaleDeviceLists interface {
 	SelectUserIDsWithStaleDeviceLists(ctx context.Context, domains []gomatrixserverlib.ServerName) ([]string, error)
 }
 
+type KeyUploads interface {
+	InsertKeyUpload(ctx context.Context, txn *sql.Tx, userID, keyType string, count int, tsAddedSecs int64) error
+	// SelectTopKeyUploaders returns the users who uploaded the most keys since the given time, most keys first.
+	SelectTopKeyUploaders(ctx context.Context, txn *sql.Tx, sinceSecs int64, limit int) ([]types.KeyUploadCount, error)
+	DeleteKeyUploadsBefore(ctx context.Context, txn *sql.Tx, beforeSecs int64) error
+}
+
+type MasterKeyChanges interface {
+	InsertMasterKeyChange(ctx context.Context, txn *sql.Tx, userID string, tsAddedSecs int64) error
+	// SelectMasterKeyChangeCount returns the number of master key changes in the window [fromSecs, toSecs).
+	SelectMasterKeyChangeCount(ctx context.Context, txn *sql.Tx, fromSecs, toSecs int64) (int64, error)
+	DeleteMasterKeyChangesBefore(ctx context.Context, txn *sql.Tx, beforeSecs int64) error
+}
+
 type CrossSigningKeys interface {
 	SelectCrossSigningKeysForUser(ctx context.Context, txn *sql.Tx, userID string) (r types.CrossSigningKeyMap, err error)
 	UpsertCrossSigningKeysForUser(ctx context.Context, txn *sql.Tx, userID string, keyType gomatrixserverlib.CrossSigningKeyPurpose, keyData gomatrixserverlib.Base64Bytes) error
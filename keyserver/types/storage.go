@@ -43,6 +43,12 @@ var KeyTypeIntToPurpose = map[int16]gomatrixserverlib.CrossSigningKeyPurpose{
 	3: gomatrixserverlib.CrossSigningKeyPurposeUserSigning,
 }
 
+// KeyUploadCount is the number of keys a user uploaded within some window.
+type KeyUploadCount struct {
+	UserID string
+	Count  int64
+}
+
 // Map of purpose -> public key
 type CrossSigningKeyMap map[gomatrixserverlib.CrossSigningKeyPurpose]gomatrixserverlib.Base64Bytes
 
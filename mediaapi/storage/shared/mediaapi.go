@@ -17,6 +17,7 @@ package shared
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	"github.com/matrix-org/dendrite/internal/sqlutil"
 	"github.com/matrix-org/dendrite/mediaapi/storage/tables"
@@ -61,6 +62,21 @@ func (d Database) GetMediaMetadataByHash(ctx context.Context, mediaHash types.Ba
 	return mediaMetadata, err
 }
 
+// MediaUploadsByDay returns the number of files uploaded to this server in
+// [from, to) for each UTC day, keyed by the start of the day in Unix epoch ms.
+// Remote media which was only fetched and cached here isn't counted.
+func (d Database) MediaUploadsByDay(ctx context.Context, from, to time.Time) (map[int64]int64, error) {
+	days, err := d.MediaRepository.SelectUploadCountsByDay(ctx, nil, gomatrixserverlib.AsTimestamp(from), gomatrixserverlib.AsTimestamp(to))
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[int64]int64, len(days))
+	for day, count := range days {
+		result[day*int64(24*time.Hour/time.Millisecond)] = count
+	}
+	return result, nil
+}
+
 // StoreThumbnail inserts the metadata about the thumbnail into the database.
 // Returns an error if the combination of MediaID and Origin are not unique in the table.
 func (d Database) StoreThumbnail(ctx context.Context, thumbnailMetadata *types.ThumbnailMetadata) error {
@@ -20,6 +20,7 @@ import (
 	"database/sql"
 	"time"
 
+	"github.com/matrix-org/dendrite/internal"
 	"github.com/matrix-org/dendrite/internal/sqlutil"
 	"github.com/matrix-org/dendrite/mediaapi/storage/tables"
 	"github.com/matrix-org/dendrite/mediaapi/types"
@@ -65,11 +66,20 @@ const selectMediaByHashSQL = `
 SELECT content_type, file_size_bytes, creation_ts, upload_name, media_id, user_id FROM mediaapi_media_repository WHERE base64hash = $1 AND media_origin = $2
 `
 
+// Remote media cached here has no uploader, so only media with a user ID was
+// uploaded to this server. Days are counted in UTC.
+const selectUploadCountsByDaySQL = `
+SELECT creation_ts / 86400000 AS day, COUNT(*) FROM mediaapi_media_repository
+    WHERE creation_ts >= $1 AND creation_ts < $2 AND user_id != ''
+    GROUP BY day
+`
+
 type mediaStatements struct {
-	db                    *sql.DB
-	insertMediaStmt       *sql.Stmt
-	selectMediaStmt       *sql.Stmt
-	selectMediaByHashStmt *sql.Stmt
+	db                          *sql.DB
+	insertMediaStmt             *sql.Stmt
+	selectMediaStmt             *sql.Stmt
+	selectMediaByHashStmt       *sql.Stmt
+	selectUploadCountsByDayStmt *sql.Stmt
 }
 
 func NewSQLiteMediaRepositoryTable(db *sql.DB) (tables.MediaRepository, error) {
@@ -85,6 +95,7 @@ func NewSQLiteMediaRepositoryTable(db *sql.DB) (tables.MediaRepository, error) {
 		{&s.insertMediaStmt, insertMediaSQL},
 		{&s.selectMediaStmt, selectMediaSQL},
 		{&s.selectMediaByHashStmt, selectMediaByHashSQL},
+		{&s.selectUploadCountsByDayStmt, selectUploadCountsByDaySQL},
 	}.Prepare(db)
 }
 
@@ -145,3 +156,22 @@ func (s *mediaStatements) SelectMediaByHash(
 	)
 	return &mediaMetadata, err
 }
+
+func (s *mediaStatements) SelectUploadCountsByDay(
+	ctx context.Context, txn *sql.Tx, from, to gomatrixserverlib.Timestamp,
+) (map[int64]int64, error) {
+	rows, err := sqlutil.TxStmtContext(ctx, txn, s.selectUploadCountsByDayStmt).QueryContext(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectUploadCountsByDay: rows.close() failed")
+	result := make(map[int64]int64)
+	for rows.Next() {
+		var day, count int64
+		if err = rows.Scan(&day, &count); err != nil {
+			return nil, err
+		}
+		result[day] = count
+	}
+	return result, rows.Err()
+}
@@ -2,13 +2,17 @@ package storage_test
 
 import (
 	"context"
+	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/matrix-org/dendrite/internal/sqlutil"
 	"github.com/matrix-org/dendrite/mediaapi/storage"
 	"github.com/matrix-org/dendrite/mediaapi/types"
 	"github.com/matrix-org/dendrite/setup/config"
 	"github.com/matrix-org/dendrite/test"
+	"github.com/matrix-org/gomatrixserverlib"
 )
 
 func mustCreateDatabase(t *testing.T, dbType test.DBType) (storage.Database, func()) {
@@ -133,3 +137,72 @@ func TestThumbnailsStorage(t *testing.T) {
 		})
 	})
 }
+
+func TestMediaUploadsByDay(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		connStr, close := test.PrepareDBConnectionString(t, dbType)
+		defer close()
+		dbOpts := &config.DatabaseOptions{
+			ConnectionString: config.DataSource(connStr),
+		}
+		db, err := storage.NewMediaAPIDatasource(dbOpts)
+		if err != nil {
+			t.Fatalf("NewMediaAPIDatasource returned %s", err)
+		}
+		ctx := context.Background()
+
+		day := int64(24 * time.Hour / time.Millisecond)
+		today := time.Now().UTC().Truncate(24 * time.Hour)
+		uploads := []struct {
+			userID    string
+			createdAt time.Time
+		}{
+			{"@alice:localhost", today.Add(-72 * time.Hour)},
+			{"@alice:localhost", today.Add(-47 * time.Hour)},
+			{"@bob:localhost", today.Add(-23 * time.Hour)},
+			{"@bob:localhost", today.Add(-22 * time.Hour)},
+			{"@alice:localhost", today.Add(time.Hour)},
+			// remote media fetched over federation has no uploader
+			{"", today.Add(-23 * time.Hour)},
+		}
+		for i, upload := range uploads {
+			if err = db.StoreMediaMetadata(ctx, &types.MediaMetadata{
+				MediaID:    types.MediaID(fmt.Sprintf("media%d", i)),
+				Origin:     "localhost",
+				UploadName: "upload",
+				Base64Hash: types.Base64Hash(fmt.Sprintf("hash%d", i)),
+				UserID:     types.MatrixUserID(upload.userID),
+			}); err != nil {
+				t.Fatalf("unable to store media metadata: %v", err)
+			}
+		}
+
+		// media is always stored as created now, so backdate it
+		rawDB, err := sqlutil.Open(dbOpts)
+		if err != nil {
+			t.Fatalf("failed to open database: %s", err)
+		}
+		defer rawDB.Close() // nolint: errcheck
+		for i, upload := range uploads {
+			if _, err = rawDB.ExecContext(ctx,
+				"UPDATE mediaapi_media_repository SET creation_ts = $1 WHERE media_id = $2",
+				gomatrixserverlib.AsTimestamp(upload.createdAt), fmt.Sprintf("media%d", i),
+			); err != nil {
+				t.Fatalf("failed to backdate media: %s", err)
+			}
+		}
+
+		got, err := db.MediaUploadsByDay(ctx, today.Add(-48*time.Hour), today)
+		if err != nil {
+			t.Fatalf("MediaUploadsByDay returned %s", err)
+		}
+		todayMS := int64(gomatrixserverlib.AsTimestamp(today))
+		want := map[int64]int64{
+			todayMS - 2*day: 1,
+			todayMS - day:   2,
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	})
+}
@@ -43,4 +43,7 @@ type MediaRepository interface {
 		ctx context.Context, txn *sql.Tx,
 		mediaHash types.Base64Hash, mediaOrigin gomatrixserverlib.ServerName,
 	) (*types.MediaMetadata, error)
+	// SelectUploadCountsByDay returns the number of local uploads created in [from, to) for each day,
+	// keyed by the number of days since the Unix epoch.
+	SelectUploadCountsByDay(ctx context.Context, txn *sql.Tx, from, to gomatrixserverlib.Timestamp) (map[int64]int64, error)
 }
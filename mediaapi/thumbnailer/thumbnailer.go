@@ -25,9 +25,38 @@ import (
 	"github.com/matrix-org/dendrite/mediaapi/storage"
 	"github.com/matrix-org/dendrite/mediaapi/types"
 	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	log "github.com/sirupsen/logrus"
 )
 
+var (
+	thumbnailsGenerated = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "dendrite",
+			Subsystem: "mediaapi",
+			Name:      "thumbnails_generated_total",
+			Help:      "Total number of thumbnails generated and stored",
+		},
+	)
+	thumbnailCacheHits = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "dendrite",
+			Subsystem: "mediaapi",
+			Name:      "thumbnail_cache_hits_total",
+			Help:      "Total number of thumbnail generations skipped because the thumbnail already existed",
+		},
+	)
+	thumbnailGenerationFailures = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "dendrite",
+			Subsystem: "mediaapi",
+			Name:      "thumbnail_generation_failures_total",
+			Help:      "Total number of thumbnail generations which failed",
+		},
+	)
+)
+
 type thumbnailFitness struct {
 	isSmaller      int
 	aspect         float64
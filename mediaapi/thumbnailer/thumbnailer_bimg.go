@@ -124,6 +124,11 @@ func createThumbnail(
 	}
 
 	dst := GetThumbnailPath(src, config)
+	defer func() {
+		if errorReturn != nil {
+			thumbnailGenerationFailures.Inc()
+		}
+	}()
 
 	// Note: getActiveThumbnailGeneration uses mutexes and conditions from activeThumbnailGeneration
 	isActive, busy, err := getActiveThumbnailGeneration(dst, config, activeThumbnailGeneration, maxThumbnailGenerators, logger)
@@ -148,9 +153,13 @@ func createThumbnail(
 	}
 
 	exists, err := isThumbnailExists(ctx, dst, config, mediaMetadata, db, logger)
-	if err != nil || exists {
+	if err != nil {
 		return false, err
 	}
+	if exists {
+		thumbnailCacheHits.Inc()
+		return false, nil
+	}
 
 	start := time.Now()
 	width, height, err := resize(dst, img, config.Width, config.Height, config.ResizeMethod == "crop", logger)
@@ -191,6 +200,7 @@ func createThumbnail(
 		}).Error("Failed to store thumbnail metadata in database.")
 		return false, err
 	}
+	thumbnailsGenerated.Inc()
 
 	return false, nil
 }
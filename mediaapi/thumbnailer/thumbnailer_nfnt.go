@@ -162,6 +162,11 @@ func createThumbnail(
 	}
 
 	dst := GetThumbnailPath(src, config)
+	defer func() {
+		if errorReturn != nil {
+			thumbnailGenerationFailures.Inc()
+		}
+	}()
 
 	// Note: getActiveThumbnailGeneration uses mutexes and conditions from activeThumbnailGeneration
 	isActive, busy, err := getActiveThumbnailGeneration(dst, config, activeThumbnailGeneration, maxThumbnailGenerators, logger)
@@ -186,9 +191,13 @@ func createThumbnail(
 	}
 
 	exists, err := isThumbnailExists(ctx, dst, config, mediaMetadata, db, logger)
-	if err != nil || exists {
+	if err != nil {
 		return false, err
 	}
+	if exists {
+		thumbnailCacheHits.Inc()
+		return false, nil
+	}
 
 	start := time.Now()
 	width, height, err := adjustSize(dst, img, config.Width, config.Height, config.ResizeMethod == types.Crop, logger)
@@ -229,6 +238,7 @@ func createThumbnail(
 		}).Error("Failed to store thumbnail metadata in database.")
 		return false, err
 	}
+	thumbnailsGenerated.Inc()
 
 	return false, nil
 }
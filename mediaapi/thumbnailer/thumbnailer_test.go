@@ -0,0 +1,102 @@
+package thumbnailer
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matrix-org/dendrite/mediaapi/storage"
+	"github.com/matrix-org/dendrite/mediaapi/types"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/test"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
+)
+
+func mustWriteImage(t *testing.T, width, height int) types.Path {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 0x80, A: 0xff})
+		}
+	}
+	src := filepath.Join(t.TempDir(), "file")
+	out, err := os.Create(src)
+	if err != nil {
+		t.Fatalf("failed to create source file: %s", err)
+	}
+	defer out.Close() // nolint: errcheck
+	if err = jpeg.Encode(out, img, nil); err != nil {
+		t.Fatalf("failed to encode source file: %s", err)
+	}
+	return types.Path(src)
+}
+
+func TestThumbnailGenerationMetrics(t *testing.T) {
+	// The counters are package globals, so run against a single database
+	// rather than in parallel subtests which would skew each other's counts.
+	connStr, close := test.PrepareDBConnectionString(t, test.DBTypeSQLite)
+	defer close()
+	db, err := storage.NewMediaAPIDatasource(&config.DatabaseOptions{
+		ConnectionString: config.DataSource(connStr),
+	})
+	if err != nil {
+		t.Fatalf("NewMediaAPIDatasource returned %s", err)
+	}
+
+	ctx := context.Background()
+	src := mustWriteImage(t, 256, 256)
+	mediaMetadata := &types.MediaMetadata{
+		MediaID: "thumbnailMetrics",
+		Origin:  "localhost",
+	}
+	activeThumbnailGeneration := &types.ActiveThumbnailGeneration{
+		PathToResult: map[string]*types.ThumbnailGenerationResult{},
+	}
+	logger := log.WithField("test", t.Name())
+	generate := func(ctx context.Context, config types.ThumbnailSize) error {
+		_, err := GenerateThumbnail(ctx, src, config, mediaMetadata, activeThumbnailGeneration, 10, db, logger)
+		return err
+	}
+
+	generated := testutil.ToFloat64(thumbnailsGenerated)
+	cacheHits := testutil.ToFloat64(thumbnailCacheHits)
+	failures := testutil.ToFloat64(thumbnailGenerationFailures)
+	assertCounts := func(wantGenerated, wantCacheHits, wantFailures float64) {
+		t.Helper()
+		if got := testutil.ToFloat64(thumbnailsGenerated) - generated; got != wantGenerated {
+			t.Errorf("expected %v generated thumbnails, got %v", wantGenerated, got)
+		}
+		if got := testutil.ToFloat64(thumbnailCacheHits) - cacheHits; got != wantCacheHits {
+			t.Errorf("expected %v thumbnail cache hits, got %v", wantCacheHits, got)
+		}
+		if got := testutil.ToFloat64(thumbnailGenerationFailures) - failures; got != wantFailures {
+			t.Errorf("expected %v thumbnail generation failures, got %v", wantFailures, got)
+		}
+	}
+
+	small := types.ThumbnailSize{Width: 32, Height: 32, ResizeMethod: types.Crop}
+	if err = generate(ctx, small); err != nil {
+		t.Fatalf("failed to generate thumbnail: %s", err)
+	}
+	assertCounts(1, 0, 0)
+
+	// the same size again must be served from the existing thumbnail
+	if err = generate(ctx, small); err != nil {
+		t.Fatalf("failed to generate thumbnail: %s", err)
+	}
+	assertCounts(1, 1, 0)
+
+	// a cancelled request can't look up the thumbnail, so generation fails
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err = generate(cancelledCtx, types.ThumbnailSize{Width: 64, Height: 64, ResizeMethod: types.Scale}); err == nil {
+		t.Fatalf("expected thumbnail generation to fail")
+	}
+	assertCounts(1, 1, 1)
+}
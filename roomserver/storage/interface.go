@@ -16,6 +16,7 @@ package storage
 
 import (
 	"context"
+	"time"
 
 	"github.com/matrix-org/dendrite/roomserver/storage/shared"
 	"github.com/matrix-org/dendrite/roomserver/storage/tables"
@@ -164,6 +165,39 @@ type Database interface {
 	GetKnownUsers(ctx context.Context, userID, searchString string, limit int) ([]string, error)
 	// GetKnownRooms returns a list of all rooms we know about.
 	GetKnownRooms(ctx context.Context) ([]string, error)
+	// RoomsByJoinRule returns the number of known rooms for each join rule in their current state.
+	RoomsByJoinRule(ctx context.Context) (map[string]int64, error)
+	// SpaceCount returns the number of rooms which are spaces.
+	SpaceCount(ctx context.Context) (int64, error)
+	// EncryptedRoomsByAlgorithm returns the number of encrypted rooms for each encryption algorithm.
+	EncryptedRoomsByAlgorithm(ctx context.Context) (map[string]int64, error)
+	// RoomsWithServerACL returns the number of rooms with a server ACL.
+	RoomsWithServerACL(ctx context.Context) (int64, error)
+	// EphemeralRoomCount returns the number of rooms whose creator left within the given duration of creating them.
+	EphemeralRoomCount(ctx context.Context, within time.Duration) (int64, error)
+	// RedactedEventCount returns the number of redaction events sent within the given window.
+	RedactedEventCount(ctx context.Context, from, to time.Time) (int64, error)
+	// RoomsBySizeBucket returns the number of rooms in each joined member count bucket.
+	RoomsBySizeBucket(ctx context.Context) (map[string]int64, error)
+	// RoomStatistics returns the number of rooms local users sent events in within the given window and the joined local member count buckets.
+	RoomStatistics(ctx context.Context, serverName gomatrixserverlib.ServerName, from, to time.Time) (*types.RoomStatistics, error)
+	// EventVolumeBySender returns the number of events sent within the given window by users of the given server and of other servers.
+	EventVolumeBySender(ctx context.Context, serverName gomatrixserverlib.ServerName, from, to time.Time) (local, remote int64, err error)
+	// AvgRoomsMessagedPerActiveUser returns the average number of distinct rooms each user of the given server who sent
+	// events within the given duration until now sent events to.
+	AvgRoomsMessagedPerActiveUser(ctx context.Context, serverName gomatrixserverlib.ServerName, window time.Duration) (float64, error)
+	// RoomFederationFanout returns the topN rooms with the most distinct remote servers joined.
+	RoomFederationFanout(ctx context.Context, topN int) ([]types.RoomFanout, error)
+	// FederationReachPerUser returns the topN local users sharing rooms with the most distinct remote servers.
+	FederationReachPerUser(ctx context.Context, topN int) ([]types.UserFederationReach, error)
+	// UsersByRoomVersion returns the number of distinct local users joined to rooms of each room version.
+	UsersByRoomVersion(ctx context.Context) (map[string]int64, error)
+	// AverageEventSizeByRoom returns the topN rooms with the largest mean event size in bytes.
+	AverageEventSizeByRoom(ctx context.Context, topN int) ([]types.RoomEventSize, error)
+	// RoomsWithManyAdmins returns the rooms where more than threshold users have an admin power level.
+	RoomsWithManyAdmins(ctx context.Context, threshold int) ([]string, error)
+	// EventsByDay returns the number of events sent on each day within the given window.
+	EventsByDay(ctx context.Context, from, to time.Time) (map[int64]int64, error)
 	// ForgetRoom sets a flag in the membership table, that the user wishes to forget a specific room
 	ForgetRoom(ctx context.Context, userID, roomID string, forget bool) error
 }
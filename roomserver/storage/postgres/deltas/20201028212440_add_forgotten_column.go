@@ -25,6 +25,8 @@ import (
 func LoadFromGoose() {
 	goose.AddMigration(UpAddForgottenColumn, DownAddForgottenColumn)
 	goose.AddMigration(UpStateBlocksRefactor, DownStateBlocksRefactor)
+	goose.AddMigration(UpEventJSONOriginServerTS, DownEventJSONOriginServerTS)
+	goose.AddMigration(UpEventJSONSender, DownEventJSONSender)
 }
 
 func LoadAddForgottenColumn(m *sqlutil.Migrations) {
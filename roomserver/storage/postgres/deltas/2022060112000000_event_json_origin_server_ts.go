@@ -0,0 +1,75 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deltas
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+)
+
+func LoadEventJSONOriginServerTS(m *sqlutil.Migrations) {
+	m.AddMigration(UpEventJSONOriginServerTS, DownEventJSONOriginServerTS)
+}
+
+// UpEventJSONOriginServerTS stores the origin_server_ts of each event next to
+// its JSON, so that events can be filtered by it without parsing the JSON of
+// every event.
+func UpEventJSONOriginServerTS(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE roomserver_event_json ADD COLUMN IF NOT EXISTS origin_server_ts BIGINT NOT NULL DEFAULT 0;`)
+	if err != nil {
+		return fmt.Errorf("failed to execute upgrade: %w", err)
+	}
+	err = backfillEventJSONColumn(tx, "origin_server_ts", `COALESCE((event_json::JSON->>'origin_server_ts')::BIGINT, 0)`)
+	if err != nil {
+		return fmt.Errorf("backfillEventJSONColumn: %w", err)
+	}
+	_, err = tx.Exec(`CREATE INDEX IF NOT EXISTS roomserver_event_json_origin_server_ts_idx ON roomserver_event_json (origin_server_ts);`)
+	if err != nil {
+		return fmt.Errorf("failed to execute upgrade: %w", err)
+	}
+	return nil
+}
+
+func DownEventJSONOriginServerTS(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP INDEX IF EXISTS roomserver_event_json_origin_server_ts_idx;
+ALTER TABLE roomserver_event_json DROP COLUMN IF EXISTS origin_server_ts;`)
+	if err != nil {
+		return fmt.Errorf("failed to execute downgrade: %w", err)
+	}
+	return nil
+}
+
+// eventJSONBackfillBatchSize is the range of event NIDs which are updated at a
+// time when filling in a new roomserver_event_json column.
+const eventJSONBackfillBatchSize = 10000
+
+// backfillEventJSONColumn sets the column of every stored event to the value of
+// the SQL expression, which is evaluated against the event JSON. The events are
+// updated in ranges of event NIDs so that each statement is bounded.
+func backfillEventJSONColumn(tx *sql.Tx, column, expr string) error {
+	var maxNID int64
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(event_nid), 0) FROM roomserver_event_json;`).Scan(&maxNID); err != nil {
+		return fmt.Errorf("tx.QueryRow.Scan (max event NID): %w", err)
+	}
+	updateSQL := `UPDATE roomserver_event_json SET ` + column + ` = ` + expr + ` WHERE event_nid > $1 AND event_nid <= $2`
+	for afterNID := int64(0); afterNID < maxNID; afterNID += eventJSONBackfillBatchSize {
+		if _, err := tx.Exec(updateSQL, afterNID, afterNID+eventJSONBackfillBatchSize); err != nil {
+			return fmt.Errorf("tx.Exec (update events): %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deltas
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+)
+
+func LoadEventJSONSender(m *sqlutil.Migrations) {
+	m.AddMigration(UpEventJSONSender, DownEventJSONSender)
+}
+
+// UpEventJSONSender stores the sender of each event next to its JSON, so that
+// events can be filtered by it without parsing the JSON of every event.
+func UpEventJSONSender(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE roomserver_event_json ADD COLUMN IF NOT EXISTS sender TEXT NOT NULL DEFAULT '';`)
+	if err != nil {
+		return fmt.Errorf("failed to execute upgrade: %w", err)
+	}
+	err = backfillEventJSONColumn(tx, "sender", `COALESCE(event_json::JSON->>'sender', '')`)
+	if err != nil {
+		return fmt.Errorf("backfillEventJSONColumn: %w", err)
+	}
+	return nil
+}
+
+func DownEventJSONSender(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE roomserver_event_json DROP COLUMN IF EXISTS sender;`)
+	if err != nil {
+		return fmt.Errorf("failed to execute downgrade: %w", err)
+	}
+	return nil
+}
@@ -125,6 +125,50 @@ var selectKnownUsersSQL = "" +
 	"  SELECT DISTINCT room_nid FROM roomserver_membership WHERE target_nid=$1 AND membership_nid = " + fmt.Sprintf("%d", tables.MembershipStateJoin) +
 	") AND membership_nid = " + fmt.Sprintf("%d", tables.MembershipStateJoin) + " AND event_state_key LIKE $2 LIMIT $3"
 
+var selectJoinedMemberCountsSQL = "" +
+	"SELECT room_nid, COUNT(*) FROM roomserver_membership" +
+	" WHERE membership_nid = " + fmt.Sprintf("%d", tables.MembershipStateJoin) + " AND forgotten = false" +
+	" GROUP BY room_nid"
+
+var selectLocalJoinedMemberCountsSQL = "" +
+	"SELECT room_nid, COUNT(*) FROM roomserver_membership" +
+	" WHERE membership_nid = " + fmt.Sprintf("%d", tables.MembershipStateJoin) + " AND target_local = true AND forgotten = false" +
+	" GROUP BY room_nid"
+
+// selectRoomFanoutSQL counts the distinct servers of joined remote members for
+// each room, taking the server name from everything after the first colon of
+// the user ID.
+var selectRoomFanoutSQL = "" +
+	"SELECT room_id, COUNT(DISTINCT SUBSTRING(event_state_key FROM POSITION(':' IN event_state_key) + 1)) AS remote_servers" +
+	" FROM roomserver_membership" +
+	" JOIN roomserver_event_state_keys ON roomserver_membership.target_nid = roomserver_event_state_keys.event_state_key_nid" +
+	" JOIN roomserver_rooms ON roomserver_membership.room_nid = roomserver_rooms.room_nid" +
+	" WHERE membership_nid = " + fmt.Sprintf("%d", tables.MembershipStateJoin) + " AND target_local = false AND forgotten = false" +
+	" GROUP BY room_id ORDER BY remote_servers DESC, room_id LIMIT $1"
+
+// selectLocalUsersByRoomVersionSQL counts the distinct local users joined to
+// rooms of each room version.
+var selectLocalUsersByRoomVersionSQL = "" +
+	"SELECT room_version, COUNT(DISTINCT target_nid) FROM roomserver_membership" +
+	" JOIN roomserver_rooms ON roomserver_membership.room_nid = roomserver_rooms.room_nid" +
+	" WHERE membership_nid = " + fmt.Sprintf("%d", tables.MembershipStateJoin) + " AND target_local = true AND forgotten = false" +
+	" GROUP BY room_version"
+
+// selectLocalUserFederationReachSQL counts the distinct servers of the remote
+// members joined to the rooms each local user is joined to, taking the server
+// name from everything after the first colon of the user ID.
+var selectLocalUserFederationReachSQL = "" +
+	"SELECT local.event_state_key, COUNT(DISTINCT SUBSTRING(remote.event_state_key FROM POSITION(':' IN remote.event_state_key) + 1)) AS servers" +
+	" FROM roomserver_membership AS local_membership" +
+	" JOIN roomserver_membership AS remote_membership ON local_membership.room_nid = remote_membership.room_nid" +
+	" JOIN roomserver_event_state_keys AS local ON local_membership.target_nid = local.event_state_key_nid" +
+	" JOIN roomserver_event_state_keys AS remote ON remote_membership.target_nid = remote.event_state_key_nid" +
+	" WHERE local_membership.membership_nid = " + fmt.Sprintf("%d", tables.MembershipStateJoin) +
+	" AND local_membership.target_local = true AND local_membership.forgotten = false" +
+	" AND remote_membership.membership_nid = " + fmt.Sprintf("%d", tables.MembershipStateJoin) +
+	" AND remote_membership.target_local = false" +
+	" GROUP BY local.event_state_key ORDER BY servers DESC, local.event_state_key LIMIT $1"
+
 // selectLocalServerInRoomSQL is an optimised case for checking if we, the local server,
 // are in the room by using the target_local column of the membership table. Normally when
 // we want to know if a server is in a room, we have to unmarshal the entire room state which
@@ -158,6 +202,11 @@ type membershipStatements struct {
 	updateMembershipForgetRoomStmt                  *sql.Stmt
 	selectLocalServerInRoomStmt                     *sql.Stmt
 	selectServerInRoomStmt                          *sql.Stmt
+	selectJoinedMemberCountsStmt                    *sql.Stmt
+	selectRoomFanoutStmt                            *sql.Stmt
+	selectLocalUsersByRoomVersionStmt               *sql.Stmt
+	selectLocalUserFederationReachStmt              *sql.Stmt
+	selectLocalJoinedMemberCountsStmt               *sql.Stmt
 }
 
 func createMembershipTable(db *sql.DB) error {
@@ -183,6 +232,11 @@ func prepareMembershipTable(db *sql.DB) (tables.Membership, error) {
 		{&s.updateMembershipForgetRoomStmt, updateMembershipForgetRoom},
 		{&s.selectLocalServerInRoomStmt, selectLocalServerInRoomSQL},
 		{&s.selectServerInRoomStmt, selectServerInRoomSQL},
+		{&s.selectJoinedMemberCountsStmt, selectJoinedMemberCountsSQL},
+		{&s.selectRoomFanoutStmt, selectRoomFanoutSQL},
+		{&s.selectLocalUsersByRoomVersionStmt, selectLocalUsersByRoomVersionSQL},
+		{&s.selectLocalUserFederationReachStmt, selectLocalUserFederationReachSQL},
+		{&s.selectLocalJoinedMemberCountsStmt, selectLocalJoinedMemberCountsSQL},
 	}.Prepare(db)
 }
 
@@ -394,3 +448,110 @@ func (s *membershipStatements) SelectServerInRoom(
 	}
 	return roomNID == nid, nil
 }
+
+func (s *membershipStatements) SelectJoinedMemberCounts(
+	ctx context.Context, txn *sql.Tx,
+) (map[types.RoomNID]int64, error) {
+	stmt := sqlutil.TxStmt(txn, s.selectJoinedMemberCountsStmt)
+	rows, err := stmt.QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectJoinedMemberCounts: rows.close() failed")
+	result := make(map[types.RoomNID]int64)
+	for rows.Next() {
+		var roomNID types.RoomNID
+		var count int64
+		if err = rows.Scan(&roomNID, &count); err != nil {
+			return nil, err
+		}
+		result[roomNID] = count
+	}
+	return result, rows.Err()
+}
+
+func (s *membershipStatements) SelectLocalJoinedMemberCounts(
+	ctx context.Context, txn *sql.Tx,
+) (map[types.RoomNID]int64, error) {
+	stmt := sqlutil.TxStmt(txn, s.selectLocalJoinedMemberCountsStmt)
+	rows, err := stmt.QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectLocalJoinedMemberCounts: rows.close() failed")
+	result := make(map[types.RoomNID]int64)
+	for rows.Next() {
+		var roomNID types.RoomNID
+		var count int64
+		if err = rows.Scan(&roomNID, &count); err != nil {
+			return nil, err
+		}
+		result[roomNID] = count
+	}
+	return result, rows.Err()
+}
+
+func (s *membershipStatements) SelectRoomFanout(
+	ctx context.Context, txn *sql.Tx, limit int,
+) ([]types.RoomFanout, error) {
+	stmt := sqlutil.TxStmt(txn, s.selectRoomFanoutStmt)
+	rows, err := stmt.QueryContext(ctx, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectRoomFanout: rows.close() failed")
+	var result []types.RoomFanout
+	for rows.Next() {
+		var fanout types.RoomFanout
+		if err = rows.Scan(&fanout.RoomID, &fanout.RemoteServers); err != nil {
+			return nil, err
+		}
+		result = append(result, fanout)
+	}
+	return result, rows.Err()
+}
+
+func (s *membershipStatements) SelectLocalUsersByRoomVersion(
+	ctx context.Context, txn *sql.Tx,
+) (map[gomatrixserverlib.RoomVersion]int64, error) {
+	stmt := sqlutil.TxStmt(txn, s.selectLocalUsersByRoomVersionStmt)
+	rows, err := stmt.QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectLocalUsersByRoomVersion: rows.close() failed")
+	result := make(map[gomatrixserverlib.RoomVersion]int64)
+	for rows.Next() {
+		var roomVersion gomatrixserverlib.RoomVersion
+		var count int64
+		if err = rows.Scan(&roomVersion, &count); err != nil {
+			return nil, err
+		}
+		result[roomVersion] = count
+	}
+	return result, rows.Err()
+}
+
+func (s *membershipStatements) SelectLocalUserFederationReach(
+	ctx context.Context, txn *sql.Tx, limit int,
+) ([]types.UserFederationReach, error) {
+	stmt := sqlutil.TxStmt(txn, s.selectLocalUserFederationReachStmt)
+	rows, err := stmt.QueryContext(ctx, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectLocalUserFederationReach: rows.close() failed")
+	var result []types.UserFederationReach
+	for rows.Next() {
+		var userID string
+		var reach types.UserFederationReach
+		if err = rows.Scan(&userID, &reach.Servers); err != nil {
+			return nil, err
+		}
+		if reach.Localpart, _, err = gomatrixserverlib.SplitID('@', userID); err != nil {
+			return nil, err
+		}
+		result = append(result, reach)
+	}
+	return result, rows.Err()
+}
@@ -53,6 +53,8 @@ func Open(dbProperties *config.DatabaseOptions, cache caching.RoomServerCaches)
 	m := sqlutil.NewMigrations()
 	deltas.LoadAddForgottenColumn(m)
 	deltas.LoadStateBlocksRefactor(m)
+	deltas.LoadEventJSONOriginServerTS(m)
+	deltas.LoadEventJSONSender(m)
 	if err := m.RunDeltas(db, dbProperties); err != nil {
 		return nil, err
 	}
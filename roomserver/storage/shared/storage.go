@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"sort"
+	"time"
 
 	"github.com/matrix-org/dendrite/internal/caching"
 	"github.com/matrix-org/dendrite/internal/sqlutil"
@@ -1076,6 +1077,26 @@ func (d *Database) GetRoomsByMembership(ctx context.Context, userID, membership
 // GetBulkStateContent returns all state events which match a given room ID and a given state key tuple. Both must be satisfied for a match.
 // If a tuple has the StateKey of '*' and allowWildcards=true then all state events with the EventType should be returned.
 func (d *Database) GetBulkStateContent(ctx context.Context, roomIDs []string, tuples []gomatrixserverlib.StateKeyTuple, allowWildcards bool) ([]tables.StrippedEvent, error) {
+	events, err := d.bulkStateEvents(ctx, roomIDs, tuples, allowWildcards)
+	if err != nil {
+		return nil, fmt.Errorf("GetBulkStateContent: %w", err)
+	}
+	result := make([]tables.StrippedEvent, len(events))
+	for i, ev := range events {
+		result[i] = tables.StrippedEvent{
+			EventType:    ev.Type(),
+			RoomID:       ev.RoomID(),
+			StateKey:     *ev.StateKey(),
+			ContentValue: tables.ExtractContentValue(ev),
+		}
+	}
+
+	return result, nil
+}
+
+// bulkStateEvents returns the current state events of the given rooms which
+// match the tuples. Unknown and stub rooms are skipped.
+func (d *Database) bulkStateEvents(ctx context.Context, roomIDs []string, tuples []gomatrixserverlib.StateKeyTuple, allowWildcards bool) ([]*gomatrixserverlib.HeaderedEvent, error) {
 	eventTypes := make([]string, 0, len(tuples))
 	for _, tuple := range tuples {
 		eventTypes = append(eventTypes, tuple.EventType)
@@ -1084,7 +1105,7 @@ func (d *Database) GetBulkStateContent(ctx context.Context, roomIDs []string, tu
 	// isn't a failure.
 	eventTypeNIDMap, err := d.EventTypesTable.BulkSelectEventTypeNID(ctx, nil, eventTypes)
 	if err != nil {
-		return nil, fmt.Errorf("GetBulkStateContent: failed to map event type nids: %w", err)
+		return nil, fmt.Errorf("failed to map event type nids: %w", err)
 	}
 	typeNIDSet := make(map[types.EventTypeNID]bool)
 	for _, nid := range eventTypeNIDMap {
@@ -1104,7 +1125,7 @@ func (d *Database) GetBulkStateContent(ctx context.Context, roomIDs []string, tu
 
 	eventStateKeyNIDMap, err := d.EventStateKeysTable.BulkSelectEventStateKeyNID(ctx, nil, eventStateKeys)
 	if err != nil {
-		return nil, fmt.Errorf("GetBulkStateContent: failed to map state key nids: %w", err)
+		return nil, fmt.Errorf("failed to map state key nids: %w", err)
 	}
 	stateKeyNIDSet := make(map[types.EventStateKeyNID]bool)
 	for _, nid := range eventStateKeyNIDMap {
@@ -1117,7 +1138,7 @@ func (d *Database) GetBulkStateContent(ctx context.Context, roomIDs []string, tu
 	for _, roomID := range roomIDs {
 		roomInfo, err2 := d.RoomInfo(ctx, roomID)
 		if err2 != nil {
-			return nil, fmt.Errorf("GetBulkStateContent: failed to load room info for room %s : %w", roomID, err2)
+			return nil, fmt.Errorf("failed to load room info for room %s : %w", roomID, err2)
 		}
 		// for unknown rooms or rooms which we don't have the current state, skip them.
 		if roomInfo == nil || roomInfo.IsStub {
@@ -1125,7 +1146,7 @@ func (d *Database) GetBulkStateContent(ctx context.Context, roomIDs []string, tu
 		}
 		entries, err2 := d.loadStateAtSnapshot(ctx, roomInfo.StateSnapshotNID)
 		if err2 != nil {
-			return nil, fmt.Errorf("GetBulkStateContent: failed to load state for room %s : %w", roomID, err2)
+			return nil, fmt.Errorf("failed to load state for room %s : %w", roomID, err2)
 		}
 		for _, entry := range entries {
 			if typeNIDSet[entry.EventTypeNID] {
@@ -1142,23 +1163,17 @@ func (d *Database) GetBulkStateContent(ctx context.Context, roomIDs []string, tu
 	}
 	events, err := d.EventJSONTable.BulkSelectEventJSON(ctx, nil, eventNIDs)
 	if err != nil {
-		return nil, fmt.Errorf("GetBulkStateContent: failed to load event JSON for event nids: %w", err)
+		return nil, fmt.Errorf("failed to load event JSON for event nids: %w", err)
 	}
-	result := make([]tables.StrippedEvent, len(events))
+	result := make([]*gomatrixserverlib.HeaderedEvent, len(events))
 	for i := range events {
 		roomVer := eventNIDToVer[events[i].EventNID]
 		ev, err := gomatrixserverlib.NewEventFromTrustedJSONWithEventID(eventIDs[events[i].EventNID], events[i].EventJSON, false, roomVer)
 		if err != nil {
-			return nil, fmt.Errorf("GetBulkStateContent: failed to load event JSON for event NID %v : %w", events[i].EventNID, err)
-		}
-		result[i] = tables.StrippedEvent{
-			EventType:    ev.Type(),
-			RoomID:       ev.RoomID(),
-			StateKey:     *ev.StateKey(),
-			ContentValue: tables.ExtractContentValue(ev.Headered(roomVer)),
+			return nil, fmt.Errorf("failed to load event JSON for event NID %v : %w", events[i].EventNID, err)
 		}
+		result[i] = ev.Headered(roomVer)
 	}
-
 	return result, nil
 }
 
@@ -1219,6 +1234,357 @@ func (d *Database) GetKnownRooms(ctx context.Context) ([]string, error) {
 	return d.RoomsTable.SelectRoomIDs(ctx, nil)
 }
 
+// RoomsByJoinRule returns the number of known rooms for each join rule in
+// their current state, e.g. "public", "invite" or "restricted".
+func (d *Database) RoomsByJoinRule(ctx context.Context) (map[string]int64, error) {
+	roomIDs, err := d.GetKnownRooms(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("d.GetKnownRooms: %w", err)
+	}
+	events, err := d.GetBulkStateContent(ctx, roomIDs, []gomatrixserverlib.StateKeyTuple{
+		{EventType: gomatrixserverlib.MRoomJoinRules, StateKey: ""},
+	}, false)
+	if err != nil {
+		return nil, fmt.Errorf("d.GetBulkStateContent: %w", err)
+	}
+	result := make(map[string]int64)
+	for _, ev := range events {
+		result[ev.ContentValue]++
+	}
+	return result, nil
+}
+
+// SpaceCount returns the number of known rooms which are spaces, i.e. whose
+// create event has a type of "m.space".
+func (d *Database) SpaceCount(ctx context.Context) (int64, error) {
+	roomIDs, err := d.GetKnownRooms(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("d.GetKnownRooms: %w", err)
+	}
+	createEvents, err := d.bulkStateEvents(ctx, roomIDs, []gomatrixserverlib.StateKeyTuple{
+		{EventType: gomatrixserverlib.MRoomCreate, StateKey: ""},
+	}, false)
+	if err != nil {
+		return 0, fmt.Errorf("d.bulkStateEvents: %w", err)
+	}
+	var count int64
+	for _, createEvent := range createEvents {
+		if gjson.GetBytes(createEvent.Content(), "type").Str == "m.space" {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// EncryptedRoomsByAlgorithm returns the number of known rooms for each
+// encryption algorithm in their current m.room.encryption state, e.g.
+// "m.megolm.v1.aes-sha2". Unencrypted rooms aren't counted.
+func (d *Database) EncryptedRoomsByAlgorithm(ctx context.Context) (map[string]int64, error) {
+	roomIDs, err := d.GetKnownRooms(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("d.GetKnownRooms: %w", err)
+	}
+	encryptionEvents, err := d.bulkStateEvents(ctx, roomIDs, []gomatrixserverlib.StateKeyTuple{
+		{EventType: "m.room.encryption", StateKey: ""},
+	}, false)
+	if err != nil {
+		return nil, fmt.Errorf("d.bulkStateEvents: %w", err)
+	}
+	counts := make(map[string]int64)
+	for _, encryptionEvent := range encryptionEvents {
+		counts[gjson.GetBytes(encryptionEvent.Content(), "algorithm").Str]++
+	}
+	return counts, nil
+}
+
+// RoomsWithServerACL returns the number of known rooms with an m.room.server_acl
+// event in their current state, whatever servers it allows or denies.
+func (d *Database) RoomsWithServerACL(ctx context.Context) (int64, error) {
+	roomIDs, err := d.GetKnownRooms(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("d.GetKnownRooms: %w", err)
+	}
+	events, err := d.GetBulkStateContent(ctx, roomIDs, []gomatrixserverlib.StateKeyTuple{
+		{EventType: "m.room.server_acl", StateKey: ""},
+	}, false)
+	if err != nil {
+		return 0, fmt.Errorf("d.GetBulkStateContent: %w", err)
+	}
+	return int64(len(events)), nil
+}
+
+// RedactedEventCount returns the number of m.room.redaction events with an
+// origin_server_ts in [from, to), whether or not the events they redact are
+// known. Rejected redactions aren't counted.
+func (d *Database) RedactedEventCount(ctx context.Context, from, to time.Time) (int64, error) {
+	count, err := d.EventJSONTable.SelectEventCountOfType(
+		ctx, nil, types.MRoomRedactionNID, int64(gomatrixserverlib.AsTimestamp(from)), int64(gomatrixserverlib.AsTimestamp(to)),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("d.EventJSONTable.SelectEventCountOfType: %w", err)
+	}
+	return count, nil
+}
+
+// EphemeralRoomCount returns the number of known rooms whose creator left or
+// was banned, or which emptied, within the given duration of creating the
+// room, which is typical of spam and test rooms.
+func (d *Database) EphemeralRoomCount(ctx context.Context, within time.Duration) (int64, error) {
+	roomIDs, err := d.GetKnownRooms(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("d.GetKnownRooms: %w", err)
+	}
+	createEvents, err := d.bulkStateEvents(ctx, roomIDs, []gomatrixserverlib.StateKeyTuple{
+		{EventType: gomatrixserverlib.MRoomCreate, StateKey: ""},
+	}, false)
+	if err != nil {
+		return 0, fmt.Errorf("d.bulkStateEvents: %w", err)
+	}
+	joinedCounts, err := d.MembershipTable.SelectJoinedMemberCounts(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("d.MembershipTable.SelectJoinedMemberCounts: %w", err)
+	}
+	joinedRoomNIDs := make([]types.RoomNID, 0, len(joinedCounts))
+	for roomNID := range joinedCounts {
+		joinedRoomNIDs = append(joinedRoomNIDs, roomNID)
+	}
+	joinedRoomIDs, err := d.RoomsTable.BulkSelectRoomIDs(ctx, nil, joinedRoomNIDs)
+	if err != nil {
+		return 0, fmt.Errorf("d.RoomsTable.BulkSelectRoomIDs: %w", err)
+	}
+	occupied := make(map[string]bool, len(joinedRoomIDs))
+	for _, roomID := range joinedRoomIDs {
+		occupied[roomID] = true
+	}
+
+	created := make(map[string]*gomatrixserverlib.HeaderedEvent, len(createEvents))
+	createdRoomIDs := make([]string, 0, len(createEvents))
+	for _, createEvent := range createEvents {
+		created[createEvent.RoomID()] = createEvent
+		createdRoomIDs = append(createdRoomIDs, createEvent.RoomID())
+	}
+
+	// the time at which each room was abandoned, either by its creator or by
+	// the last of its members
+	abandoned := make(map[string]time.Time)
+	for _, roomID := range createdRoomIDs {
+		memberEvent, err := d.GetStateEvent(ctx, roomID, gomatrixserverlib.MRoomMember, created[roomID].Sender())
+		if err != nil {
+			return 0, fmt.Errorf("d.GetStateEvent: %w", err)
+		}
+		if memberEvent == nil {
+			continue
+		}
+		membership, err := memberEvent.Membership()
+		if err != nil || (membership != gomatrixserverlib.Leave && membership != gomatrixserverlib.Ban) {
+			continue
+		}
+		abandoned[roomID] = memberEvent.OriginServerTS().Time()
+	}
+	var emptiedRoomIDs []string
+	for _, roomID := range createdRoomIDs {
+		if _, ok := abandoned[roomID]; !ok && !occupied[roomID] {
+			emptiedRoomIDs = append(emptiedRoomIDs, roomID)
+		}
+	}
+	if len(emptiedRoomIDs) > 0 {
+		memberEvents, err := d.bulkStateEvents(ctx, emptiedRoomIDs, []gomatrixserverlib.StateKeyTuple{
+			{EventType: gomatrixserverlib.MRoomMember, StateKey: "*"},
+		}, true)
+		if err != nil {
+			return 0, fmt.Errorf("d.bulkStateEvents: %w", err)
+		}
+		// a room without joined members emptied when its last member left
+		for _, memberEvent := range memberEvents {
+			if ts := memberEvent.OriginServerTS().Time(); ts.After(abandoned[memberEvent.RoomID()]) {
+				abandoned[memberEvent.RoomID()] = ts
+			}
+		}
+	}
+
+	var count int64
+	for roomID, ts := range abandoned {
+		if ts.Sub(created[roomID].OriginServerTS().Time()) <= within {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// RoomsBySizeBucket returns the number of rooms in each joined member count
+// bucket: "1", "2-10", "11-100", "101-1000" and "1000+". Rooms without any
+// joined members are not counted.
+func (d *Database) RoomsBySizeBucket(ctx context.Context) (map[string]int64, error) {
+	counts, err := d.MembershipTable.SelectJoinedMemberCounts(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("d.MembershipTable.SelectJoinedMemberCounts: %w", err)
+	}
+	result := make(map[string]int64)
+	for _, count := range counts {
+		result[roomSizeBucket(count)]++
+	}
+	return result, nil
+}
+
+// RoomStatistics returns the number of rooms in which users of the given
+// server sent events with an origin_server_ts in [from, to), along with the
+// number of rooms in each joined local member count bucket. Rejected events
+// aren't counted.
+func (d *Database) RoomStatistics(ctx context.Context, serverName gomatrixserverlib.ServerName, from, to time.Time) (*types.RoomStatistics, error) {
+	activeRooms, err := d.EventJSONTable.SelectActiveRoomCount(
+		ctx, nil, serverName, int64(gomatrixserverlib.AsTimestamp(from)), int64(gomatrixserverlib.AsTimestamp(to)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("d.EventJSONTable.SelectActiveRoomCount: %w", err)
+	}
+	counts, err := d.MembershipTable.SelectLocalJoinedMemberCounts(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("d.MembershipTable.SelectLocalJoinedMemberCounts: %w", err)
+	}
+	stats := &types.RoomStatistics{
+		ActiveRooms:         activeRooms,
+		RoomsByLocalMembers: make(map[string]int64),
+	}
+	for _, count := range counts {
+		stats.RoomsByLocalMembers[roomSizeBucket(count)]++
+	}
+	return stats, nil
+}
+
+// EventVolumeBySender returns the number of events with an origin_server_ts in
+// [from, to) sent by users of the given server, and the number sent by users
+// of other servers. Rejected events aren't counted.
+func (d *Database) EventVolumeBySender(ctx context.Context, serverName gomatrixserverlib.ServerName, from, to time.Time) (local, remote int64, err error) {
+	local, remote, err = d.EventJSONTable.SelectEventCountsBySenderServer(
+		ctx, nil, serverName, int64(gomatrixserverlib.AsTimestamp(from)), int64(gomatrixserverlib.AsTimestamp(to)),
+	)
+	if err != nil {
+		return 0, 0, fmt.Errorf("d.EventJSONTable.SelectEventCountsBySenderServer: %w", err)
+	}
+	return local, remote, nil
+}
+
+// AvgRoomsMessagedPerActiveUser returns the average number of distinct rooms
+// the active users of the given server sent events to, where the active users
+// are those who sent any event with an origin_server_ts within the given
+// duration until now. Rejected events aren't counted. Returns 0 if there were
+// no active users.
+func (d *Database) AvgRoomsMessagedPerActiveUser(ctx context.Context, serverName gomatrixserverlib.ServerName, window time.Duration) (float64, error) {
+	now := time.Now()
+	senders, senderRooms, err := d.EventJSONTable.SelectSenderRoomCounts(
+		ctx, nil, serverName, int64(gomatrixserverlib.AsTimestamp(now.Add(-window))), int64(gomatrixserverlib.AsTimestamp(now)),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("d.EventJSONTable.SelectSenderRoomCounts: %w", err)
+	}
+	if senders == 0 {
+		return 0, nil
+	}
+	return float64(senderRooms) / float64(senders), nil
+}
+
+func roomSizeBucket(joinedMembers int64) string {
+	switch {
+	case joinedMembers <= 1:
+		return "1"
+	case joinedMembers <= 10:
+		return "2-10"
+	case joinedMembers <= 100:
+		return "11-100"
+	case joinedMembers <= 1000:
+		return "101-1000"
+	default:
+		return "1000+"
+	}
+}
+
+// RoomFederationFanout returns the topN rooms with the most distinct remote
+// servers among their joined members, i.e. the rooms whose events cost the
+// most to federate.
+func (d *Database) RoomFederationFanout(ctx context.Context, topN int) ([]types.RoomFanout, error) {
+	return d.MembershipTable.SelectRoomFanout(ctx, nil, topN)
+}
+
+// FederationReachPerUser returns the topN local users with the most distinct
+// remote servers joined to the rooms they are joined to. Users who only share
+// rooms with other local users aren't returned.
+func (d *Database) FederationReachPerUser(ctx context.Context, topN int) ([]types.UserFederationReach, error) {
+	return d.MembershipTable.SelectLocalUserFederationReach(ctx, nil, topN)
+}
+
+// UsersByRoomVersion returns the number of distinct local users joined to at
+// least one room of each room version. A user joined to rooms of several
+// versions is counted once for each of them.
+func (d *Database) UsersByRoomVersion(ctx context.Context) (map[string]int64, error) {
+	counts, err := d.MembershipTable.SelectLocalUsersByRoomVersion(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]int64, len(counts))
+	for roomVersion, count := range counts {
+		result[string(roomVersion)] = count
+	}
+	return result, nil
+}
+
+// AverageEventSizeByRoom returns the topN rooms with the largest mean event
+// size, measured over the stored JSON of the events in the room.
+func (d *Database) AverageEventSizeByRoom(ctx context.Context, topN int) ([]types.RoomEventSize, error) {
+	return d.EventJSONTable.SelectAverageEventSizes(ctx, nil, topN)
+}
+
+// RoomsWithManyAdmins returns the IDs of known rooms where more than threshold
+// users have an admin (100) power level in the room's current state. A sudden
+// increase in admins is often a sign that a room has been taken over.
+func (d *Database) RoomsWithManyAdmins(ctx context.Context, threshold int) ([]string, error) {
+	roomIDs, err := d.GetKnownRooms(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("d.GetKnownRooms: %w", err)
+	}
+	events, err := d.bulkStateEvents(ctx, roomIDs, []gomatrixserverlib.StateKeyTuple{
+		{EventType: gomatrixserverlib.MRoomPowerLevels, StateKey: ""},
+	}, false)
+	if err != nil {
+		return nil, fmt.Errorf("d.bulkStateEvents: %w", err)
+	}
+	var result []string
+	for _, ev := range events {
+		powerLevels, err := gomatrixserverlib.NewPowerLevelContentFromEvent(ev.Event)
+		if err != nil {
+			// a malformed power levels event can't grant anyone admin
+			continue
+		}
+		admins := 0
+		for _, level := range powerLevels.Users {
+			if level >= 100 {
+				admins++
+			}
+		}
+		if admins > threshold {
+			result = append(result, ev.RoomID())
+		}
+	}
+	return result, nil
+}
+
+// EventsByDay returns the number of events with an origin_server_ts in
+// [from, to) for each UTC day, keyed by the start of the day in Unix epoch ms.
+// The origin_server_ts is set by the sending server, so events from servers
+// with a skewed clock may land on the wrong day. Rejected events aren't counted.
+func (d *Database) EventsByDay(ctx context.Context, from, to time.Time) (map[int64]int64, error) {
+	days, err := d.EventJSONTable.SelectEventCountsByDay(
+		ctx, nil, int64(gomatrixserverlib.AsTimestamp(from)), int64(gomatrixserverlib.AsTimestamp(to)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("d.EventJSONTable.SelectEventCountsByDay: %w", err)
+	}
+	result := make(map[int64]int64, len(days))
+	for day, count := range days {
+		result[day*int64(24*time.Hour/time.Millisecond)] = count
+	}
+	return result, nil
+}
+
 // ForgetRoom sets a users room to forgotten
 func (d *Database) ForgetRoom(ctx context.Context, userID, roomID string, forget bool) error {
 	roomNIDs, err := d.RoomsTable.BulkSelectRoomNIDs(ctx, nil, []string{roomID})
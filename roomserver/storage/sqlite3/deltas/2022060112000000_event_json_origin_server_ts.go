@@ -0,0 +1,120 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deltas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/matrix-org/dendrite/internal"
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/tidwall/gjson"
+)
+
+func LoadEventJSONOriginServerTS(m *sqlutil.Migrations) {
+	m.AddMigration(UpEventJSONOriginServerTS, DownEventJSONOriginServerTS)
+}
+
+// UpEventJSONOriginServerTS stores the origin_server_ts of each event next to
+// its JSON, as SQLite may be built without JSON support and so can't filter
+// events by it otherwise.
+func UpEventJSONOriginServerTS(tx *sql.Tx) error {
+	// new databases are created with the column already
+	var exists bool
+	if err := tx.QueryRow(
+		`SELECT COUNT(*) > 0 FROM pragma_table_info('roomserver_event_json') WHERE name = 'origin_server_ts'`,
+	).Scan(&exists); err != nil {
+		return fmt.Errorf("tx.QueryRow.Scan (column exists): %w", err)
+	}
+	if !exists {
+		if _, err := tx.Exec(`ALTER TABLE roomserver_event_json ADD COLUMN origin_server_ts INTEGER NOT NULL DEFAULT 0;`); err != nil {
+			return fmt.Errorf("failed to execute upgrade: %w", err)
+		}
+	}
+
+	err := backfillEventJSONColumn(tx, "origin_server_ts", func(eventJSON []byte) interface{} {
+		return gjson.GetBytes(eventJSON, "origin_server_ts").Int()
+	})
+	if err != nil {
+		return fmt.Errorf("backfillEventJSONColumn: %w", err)
+	}
+
+	if _, err = tx.Exec(`CREATE INDEX IF NOT EXISTS roomserver_event_json_origin_server_ts_idx ON roomserver_event_json (origin_server_ts);`); err != nil {
+		return fmt.Errorf("failed to execute upgrade: %w", err)
+	}
+	return nil
+}
+
+// eventJSONBackfillBatchSize is the number of events which are read at a time
+// when filling in a new roomserver_event_json column.
+const eventJSONBackfillBatchSize = 1000
+
+// backfillEventJSONColumn sets the column of every stored event to the value
+// extracted from its JSON. The events are read in batches by event NID so that
+// the whole table isn't loaded into memory at once.
+func backfillEventJSONColumn(tx *sql.Tx, column string, value func(eventJSON []byte) interface{}) error {
+	updateStmt, err := tx.Prepare(`UPDATE roomserver_event_json SET ` + column + ` = $1 WHERE event_nid = $2`)
+	if err != nil {
+		return fmt.Errorf("tx.Prepare: %w", err)
+	}
+	defer internal.CloseAndLogIfError(context.TODO(), updateStmt, "updateStmt.close() failed")
+	for afterNID := int64(0); ; {
+		var count int
+		count, afterNID, err = backfillEventJSONBatch(tx, updateStmt, afterNID, value)
+		if err != nil {
+			return err
+		}
+		if count < eventJSONBackfillBatchSize {
+			return nil
+		}
+	}
+}
+
+// backfillEventJSONBatch updates the next batch of events after the event NID
+// afterNID, returning the number of events updated and the last event NID.
+func backfillEventJSONBatch(
+	tx *sql.Tx, updateStmt *sql.Stmt, afterNID int64, value func(eventJSON []byte) interface{},
+) (count int, lastNID int64, err error) {
+	rows, err := tx.Query(
+		`SELECT event_nid, event_json FROM roomserver_event_json WHERE event_nid > $1 ORDER BY event_nid ASC LIMIT $2`,
+		afterNID, eventJSONBackfillBatchSize,
+	)
+	if err != nil {
+		return 0, 0, fmt.Errorf("tx.Query: %w", err)
+	}
+	defer internal.CloseAndLogIfError(context.TODO(), rows, "rows.close() failed")
+	lastNID = afterNID
+	for rows.Next() {
+		var eventJSON []byte
+		if err = rows.Scan(&lastNID, &eventJSON); err != nil {
+			return 0, 0, fmt.Errorf("rows.Scan: %w", err)
+		}
+		if _, err = updateStmt.Exec(value(eventJSON), lastNID); err != nil {
+			return 0, 0, fmt.Errorf("updateStmt.Exec: %w", err)
+		}
+		count++
+	}
+	return count, lastNID, rows.Err()
+}
+
+func DownEventJSONOriginServerTS(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP INDEX IF EXISTS roomserver_event_json_origin_server_ts_idx;
+ALTER TABLE roomserver_event_json DROP COLUMN origin_server_ts;`)
+	if err != nil {
+		return fmt.Errorf("failed to execute downgrade: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deltas
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/tidwall/gjson"
+)
+
+func LoadEventJSONSender(m *sqlutil.Migrations) {
+	m.AddMigration(UpEventJSONSender, DownEventJSONSender)
+}
+
+// UpEventJSONSender stores the sender of each event next to its JSON, as
+// SQLite may be built without JSON support and so can't filter events by it
+// otherwise.
+func UpEventJSONSender(tx *sql.Tx) error {
+	// new databases are created with the column already
+	var exists bool
+	if err := tx.QueryRow(
+		`SELECT COUNT(*) > 0 FROM pragma_table_info('roomserver_event_json') WHERE name = 'sender'`,
+	).Scan(&exists); err != nil {
+		return fmt.Errorf("tx.QueryRow.Scan (column exists): %w", err)
+	}
+	if !exists {
+		if _, err := tx.Exec(`ALTER TABLE roomserver_event_json ADD COLUMN sender TEXT NOT NULL DEFAULT '';`); err != nil {
+			return fmt.Errorf("failed to execute upgrade: %w", err)
+		}
+	}
+
+	err := backfillEventJSONColumn(tx, "sender", func(eventJSON []byte) interface{} {
+		return gjson.GetBytes(eventJSON, "sender").Str
+	})
+	if err != nil {
+		return fmt.Errorf("backfillEventJSONColumn: %w", err)
+	}
+	return nil
+}
+
+func DownEventJSONSender(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE roomserver_event_json DROP COLUMN sender;`)
+	if err != nil {
+		return fmt.Errorf("failed to execute downgrade: %w", err)
+	}
+	return nil
+}
@@ -24,17 +24,24 @@ import (
 	"github.com/matrix-org/dendrite/internal/sqlutil"
 	"github.com/matrix-org/dendrite/roomserver/storage/tables"
 	"github.com/matrix-org/dendrite/roomserver/types"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/tidwall/gjson"
 )
 
 const eventJSONSchema = `
   CREATE TABLE IF NOT EXISTS roomserver_event_json (
     event_nid INTEGER NOT NULL PRIMARY KEY,
-    event_json TEXT NOT NULL
+    event_json TEXT NOT NULL,
+    -- The origin_server_ts and sender of the event, so that events can be
+    -- filtered by them without SQLite's JSON support. The origin_server_ts
+    -- is indexed by a migration.
+    origin_server_ts INTEGER NOT NULL DEFAULT 0,
+    sender TEXT NOT NULL DEFAULT ''
   );
 `
 
 const insertEventJSONSQL = `
-	INSERT OR REPLACE INTO roomserver_event_json (event_nid, event_json) VALUES ($1, $2)
+	INSERT OR REPLACE INTO roomserver_event_json (event_nid, event_json, origin_server_ts, sender) VALUES ($1, $2, $3, $4)
 `
 
 // Bulk event JSON lookup by numeric event ID.
@@ -46,10 +53,67 @@ const bulkSelectEventJSONSQL = `
 	  ORDER BY event_nid ASC
 `
 
+// selectAverageEventSizesSQL returns the mean size in bytes of the stored event
+// JSON for the rooms with the largest events.
+const selectAverageEventSizesSQL = "" +
+	"SELECT room_id, AVG(LENGTH(CAST(event_json AS BLOB))) AS avg_bytes FROM roomserver_event_json" +
+	" JOIN roomserver_events ON roomserver_event_json.event_nid = roomserver_events.event_nid" +
+	" JOIN roomserver_rooms ON roomserver_events.room_nid = roomserver_rooms.room_nid" +
+	" GROUP BY room_id ORDER BY avg_bytes DESC, room_id LIMIT $1"
+
+// selectEventCountsByDaySQL counts the events which aren't rejected by the
+// day of their origin_server_ts, within [$1, $2).
+const selectEventCountsByDaySQL = "" +
+	"SELECT origin_server_ts / 86400000 AS day, COUNT(*) FROM roomserver_event_json" +
+	" JOIN roomserver_events ON roomserver_event_json.event_nid = roomserver_events.event_nid" +
+	" WHERE origin_server_ts >= $1 AND origin_server_ts < $2 AND is_rejected = 0" +
+	" GROUP BY day"
+
+// selectActiveRoomCountSQL counts the rooms with events which aren't rejected
+// with an origin_server_ts within [$1, $2), sent by users of the server $3.
+const selectActiveRoomCountSQL = "" +
+	"SELECT COUNT(DISTINCT room_nid) FROM roomserver_event_json" +
+	" JOIN roomserver_events ON roomserver_event_json.event_nid = roomserver_events.event_nid" +
+	" WHERE origin_server_ts >= $1 AND origin_server_ts < $2 AND is_rejected = 0" +
+	" AND SUBSTR(sender, INSTR(sender, ':') + 1) = $3"
+
+// selectEventCountsBySenderServerSQL counts the events which aren't rejected
+// with an origin_server_ts within [$2, $3), along with how many of them were
+// sent by users of the server $1.
+const selectEventCountsBySenderServerSQL = "" +
+	"SELECT COALESCE(SUM(CASE WHEN SUBSTR(sender, INSTR(sender, ':') + 1) = $1 THEN 1 ELSE 0 END), 0), COUNT(*)" +
+	" FROM roomserver_event_json" +
+	" JOIN roomserver_events ON roomserver_event_json.event_nid = roomserver_events.event_nid" +
+	" WHERE origin_server_ts >= $2 AND origin_server_ts < $3 AND is_rejected = 0"
+
+// selectSenderRoomCountsSQL counts the users of the server $3 who sent events
+// which aren't rejected with an origin_server_ts within [$1, $2), along with
+// the number of distinct pairs of those users and the rooms they sent to.
+const selectSenderRoomCountsSQL = "" +
+	"SELECT COUNT(DISTINCT sender), COUNT(*) FROM (" +
+	" SELECT DISTINCT sender, room_nid FROM roomserver_event_json" +
+	" JOIN roomserver_events ON roomserver_event_json.event_nid = roomserver_events.event_nid" +
+	" WHERE origin_server_ts >= $1 AND origin_server_ts < $2 AND is_rejected = 0" +
+	" AND SUBSTR(sender, INSTR(sender, ':') + 1) = $3" +
+	") AS sender_rooms"
+
+// selectEventCountOfTypeSQL counts the events of the type $3 which aren't
+// rejected with an origin_server_ts within [$1, $2).
+const selectEventCountOfTypeSQL = "" +
+	"SELECT COUNT(*) FROM roomserver_event_json" +
+	" JOIN roomserver_events ON roomserver_event_json.event_nid = roomserver_events.event_nid" +
+	" WHERE origin_server_ts >= $1 AND origin_server_ts < $2 AND event_type_nid = $3 AND is_rejected = 0"
+
 type eventJSONStatements struct {
-	db                      *sql.DB
-	insertEventJSONStmt     *sql.Stmt
-	bulkSelectEventJSONStmt *sql.Stmt
+	db                                  *sql.DB
+	insertEventJSONStmt                 *sql.Stmt
+	bulkSelectEventJSONStmt             *sql.Stmt
+	selectAverageEventSizesStmt         *sql.Stmt
+	selectEventCountsByDayStmt          *sql.Stmt
+	selectActiveRoomCountStmt           *sql.Stmt
+	selectEventCountOfTypeStmt          *sql.Stmt
+	selectEventCountsBySenderServerStmt *sql.Stmt
+	selectSenderRoomCountsStmt          *sql.Stmt
 }
 
 func createEventJSONTable(db *sql.DB) error {
@@ -65,13 +129,20 @@ func prepareEventJSONTable(db *sql.DB) (tables.EventJSON, error) {
 	return s, sqlutil.StatementList{
 		{&s.insertEventJSONStmt, insertEventJSONSQL},
 		{&s.bulkSelectEventJSONStmt, bulkSelectEventJSONSQL},
+		{&s.selectAverageEventSizesStmt, selectAverageEventSizesSQL},
+		{&s.selectEventCountsByDayStmt, selectEventCountsByDaySQL},
+		{&s.selectActiveRoomCountStmt, selectActiveRoomCountSQL},
+		{&s.selectEventCountOfTypeStmt, selectEventCountOfTypeSQL},
+		{&s.selectEventCountsBySenderServerStmt, selectEventCountsBySenderServerSQL},
+		{&s.selectSenderRoomCountsStmt, selectSenderRoomCountsSQL},
 	}.Prepare(db)
 }
 
 func (s *eventJSONStatements) InsertEventJSON(
 	ctx context.Context, txn *sql.Tx, eventNID types.EventNID, eventJSON []byte,
 ) error {
-	_, err := sqlutil.TxStmt(txn, s.insertEventJSONStmt).ExecContext(ctx, int64(eventNID), eventJSON)
+	fields := gjson.GetManyBytes(eventJSON, "origin_server_ts", "sender")
+	_, err := sqlutil.TxStmt(txn, s.insertEventJSONStmt).ExecContext(ctx, int64(eventNID), eventJSON, fields[0].Int(), fields[1].Str)
 	return err
 }
 
@@ -111,3 +182,78 @@ func (s *eventJSONStatements) BulkSelectEventJSON(
 	}
 	return results[:i], nil
 }
+
+func (s *eventJSONStatements) SelectAverageEventSizes(
+	ctx context.Context, txn *sql.Tx, limit int,
+) ([]types.RoomEventSize, error) {
+	stmt := sqlutil.TxStmt(txn, s.selectAverageEventSizesStmt)
+	rows, err := stmt.QueryContext(ctx, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectAverageEventSizes: rows.close() failed")
+	var result []types.RoomEventSize
+	for rows.Next() {
+		var size types.RoomEventSize
+		if err = rows.Scan(&size.RoomID, &size.AvgBytes); err != nil {
+			return nil, err
+		}
+		result = append(result, size)
+	}
+	return result, rows.Err()
+}
+
+func (s *eventJSONStatements) SelectEventCountsByDay(
+	ctx context.Context, txn *sql.Tx, fromTS, toTS int64,
+) (map[int64]int64, error) {
+	stmt := sqlutil.TxStmt(txn, s.selectEventCountsByDayStmt)
+	rows, err := stmt.QueryContext(ctx, fromTS, toTS)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectEventCountsByDay: rows.close() failed")
+	result := make(map[int64]int64)
+	for rows.Next() {
+		var day, count int64
+		if err = rows.Scan(&day, &count); err != nil {
+			return nil, err
+		}
+		result[day] = count
+	}
+	return result, rows.Err()
+}
+
+func (s *eventJSONStatements) SelectActiveRoomCount(
+	ctx context.Context, txn *sql.Tx, serverName gomatrixserverlib.ServerName, fromTS, toTS int64,
+) (count int64, err error) {
+	stmt := sqlutil.TxStmt(txn, s.selectActiveRoomCountStmt)
+	err = stmt.QueryRowContext(ctx, fromTS, toTS, serverName).Scan(&count)
+	return
+}
+
+func (s *eventJSONStatements) SelectEventCountOfType(
+	ctx context.Context, txn *sql.Tx, eventTypeNID types.EventTypeNID, fromTS, toTS int64,
+) (count int64, err error) {
+	stmt := sqlutil.TxStmt(txn, s.selectEventCountOfTypeStmt)
+	err = stmt.QueryRowContext(ctx, fromTS, toTS, int64(eventTypeNID)).Scan(&count)
+	return
+}
+
+func (s *eventJSONStatements) SelectEventCountsBySenderServer(
+	ctx context.Context, txn *sql.Tx, serverName gomatrixserverlib.ServerName, fromTS, toTS int64,
+) (local, remote int64, err error) {
+	var total int64
+	stmt := sqlutil.TxStmt(txn, s.selectEventCountsBySenderServerStmt)
+	if err = stmt.QueryRowContext(ctx, serverName, fromTS, toTS).Scan(&local, &total); err != nil {
+		return 0, 0, err
+	}
+	return local, total - local, nil
+}
+
+func (s *eventJSONStatements) SelectSenderRoomCounts(
+	ctx context.Context, txn *sql.Tx, serverName gomatrixserverlib.ServerName, fromTS, toTS int64,
+) (senders, senderRooms int64, err error) {
+	stmt := sqlutil.TxStmt(txn, s.selectSenderRoomCountsStmt)
+	err = stmt.QueryRowContext(ctx, fromTS, toTS, serverName).Scan(&senders, &senderRooms)
+	return
+}
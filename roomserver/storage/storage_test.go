@@ -0,0 +1,759 @@
+package storage_test
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/dendrite/internal/caching"
+	"github.com/matrix-org/dendrite/roomserver/storage"
+	"github.com/matrix-org/dendrite/roomserver/types"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/test"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+func mustCreateDatabase(t *testing.T, dbType test.DBType) (storage.Database, func()) {
+	connStr, close := test.PrepareDBConnectionString(t, dbType)
+	cache, err := caching.NewInMemoryLRUCache(false)
+	if err != nil {
+		t.Fatalf("failed to create cache: %s", err)
+	}
+	db, err := storage.Open(&config.DatabaseOptions{
+		ConnectionString: config.DataSource(connStr),
+	}, cache)
+	if err != nil {
+		t.Fatalf("storage.Open returned %s", err)
+	}
+	return db, close
+}
+
+// mustStoreRoom persists all events of the given room, points the room's
+// current state at the state after the last event and records the resulting
+// memberships, without running the events through the input pipeline.
+func mustStoreRoom(t *testing.T, db storage.Database, room *test.Room) {
+	t.Helper()
+	ctx := context.Background()
+	var (
+		roomNID types.RoomNID
+		latest  types.StateAtEventAndReference
+		state   = map[types.StateKeyTuple]types.EventNID{}
+	)
+	for _, ev := range room.Events() {
+		eventNID, nid, stateAtEvent, _, _, err := db.StoreEvent(ctx, ev.Unwrap(), nil, false)
+		if err != nil {
+			t.Fatalf("failed to store event %s: %s", ev.EventID(), err)
+		}
+		if stateAtEvent.IsStateEvent() {
+			state[stateAtEvent.StateKeyTuple] = eventNID
+		}
+		roomNID = nid
+		latest = types.StateAtEventAndReference{
+			StateAtEvent:   stateAtEvent,
+			EventReference: ev.EventReference(),
+		}
+	}
+	entries := make([]types.StateEntry, 0, len(state))
+	for tuple, eventNID := range state {
+		entries = append(entries, types.StateEntry{StateKeyTuple: tuple, EventNID: eventNID})
+	}
+	snapshotNID, err := db.AddState(ctx, roomNID, nil, entries)
+	if err != nil {
+		t.Fatalf("failed to add state: %s", err)
+	}
+	roomInfo, err := db.RoomInfo(ctx, room.ID)
+	if err != nil || roomInfo == nil {
+		t.Fatalf("failed to get room info: %v", err)
+	}
+	updater, err := db.GetRoomUpdater(ctx, roomInfo)
+	if err != nil {
+		t.Fatalf("failed to get room updater: %s", err)
+	}
+	if err = updater.SetLatestEvents(roomNID, []types.StateAtEventAndReference{latest}, latest.EventNID, snapshotNID); err != nil {
+		t.Fatalf("failed to set latest events: %s", err)
+	}
+	if err = updater.Commit(); err != nil {
+		t.Fatalf("failed to commit room updater: %s", err)
+	}
+	for _, ev := range room.Events() {
+		if ev.Type() != gomatrixserverlib.MRoomMember {
+			continue
+		}
+		membership, err := ev.Membership()
+		if err != nil {
+			t.Fatalf("failed to get membership of %s: %s", ev.EventID(), err)
+		}
+		_, domain, err := gomatrixserverlib.SplitID('@', *ev.StateKey())
+		if err != nil {
+			t.Fatalf("invalid member %q: %s", *ev.StateKey(), err)
+		}
+		mu, err := db.MembershipUpdater(ctx, room.ID, *ev.StateKey(), domain == "localhost", room.Version)
+		if err != nil {
+			t.Fatalf("failed to get membership updater: %s", err)
+		}
+		switch membership {
+		case gomatrixserverlib.Join:
+			_, err = mu.SetToJoin(ev.Sender(), ev.EventID(), mu.IsJoin())
+		case gomatrixserverlib.Leave, gomatrixserverlib.Ban:
+			_, err = mu.SetToLeave(ev.Sender(), ev.EventID())
+		}
+		if err != nil {
+			t.Fatalf("failed to update membership: %s", err)
+		}
+		if err = mu.Commit(); err != nil {
+			t.Fatalf("failed to commit membership updater: %s", err)
+		}
+	}
+}
+
+func TestRoomsByJoinRule(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+
+		alice := test.NewUser()
+		restricted := test.NewRoom(t, alice, test.RoomPreset(test.PresetPrivateChat))
+		restricted.CreateAndInsert(t, alice, gomatrixserverlib.MRoomJoinRules, map[string]interface{}{
+			"join_rule": "restricted",
+		}, test.WithStateKey(""))
+		for _, room := range []*test.Room{
+			test.NewRoom(t, alice, test.RoomPreset(test.PresetPublicChat)),
+			test.NewRoom(t, alice, test.RoomPreset(test.PresetPublicChat)),
+			test.NewRoom(t, alice, test.RoomPreset(test.PresetPrivateChat)),
+			restricted,
+		} {
+			mustStoreRoom(t, db, room)
+		}
+
+		got, err := db.RoomsByJoinRule(context.Background())
+		if err != nil {
+			t.Fatalf("RoomsByJoinRule returned %s", err)
+		}
+		want := map[string]int64{"public": 2, "invite": 1, "restricted": 1}
+		if len(got) != len(want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		for joinRule, count := range want {
+			if got[joinRule] != count {
+				t.Fatalf("got %d rooms for join rule %q, want %d (%v)", got[joinRule], joinRule, count, got)
+			}
+		}
+	})
+}
+
+func TestAverageEventSizeByRoom(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+
+		alice := test.NewUser()
+		withMessages := func(count, bodySize int) *test.Room {
+			room := test.NewRoom(t, alice)
+			for i := 0; i < count; i++ {
+				room.CreateAndInsert(t, alice, "m.room.message", map[string]interface{}{
+					"msgtype": "m.text",
+					"body":    strings.Repeat("a", bodySize),
+				})
+			}
+			return room
+		}
+		averageSize := func(room *test.Room) float64 {
+			var total int
+			for _, ev := range room.Events() {
+				total += len(ev.JSON())
+			}
+			return float64(total) / float64(len(room.Events()))
+		}
+		large := withMessages(5, 4000)
+		medium := withMessages(1, 1000)
+		small := withMessages(0, 0)
+		for _, room := range []*test.Room{small, large, medium} {
+			mustStoreRoom(t, db, room)
+		}
+
+		got, err := db.AverageEventSizeByRoom(context.Background(), 2)
+		if err != nil {
+			t.Fatalf("AverageEventSizeByRoom returned %s", err)
+		}
+		want := []types.RoomEventSize{
+			{RoomID: large.ID, AvgBytes: averageSize(large)},
+			{RoomID: medium.ID, AvgBytes: averageSize(medium)},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestRoomsWithManyAdmins(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+
+		alice, bob, charlie := test.NewUser(), test.NewUser(), test.NewUser()
+		withAdmins := func(admins ...*test.User) *test.Room {
+			room := test.NewRoom(t, alice)
+			users := map[string]int64{alice.ID: 100, bob.ID: 50, charlie.ID: 50}
+			for _, admin := range admins {
+				users[admin.ID] = 100
+			}
+			room.CreateAndInsert(t, alice, gomatrixserverlib.MRoomPowerLevels, map[string]interface{}{
+				"users": users,
+			}, test.WithStateKey(""))
+			return room
+		}
+		justAlice := test.NewRoom(t, alice)
+		twoAdmins := withAdmins(bob)
+		threeAdmins := withAdmins(bob, charlie)
+		for _, room := range []*test.Room{justAlice, twoAdmins, threeAdmins} {
+			mustStoreRoom(t, db, room)
+		}
+
+		for threshold, want := range map[int][]string{
+			0: {justAlice.ID, twoAdmins.ID, threeAdmins.ID},
+			1: {twoAdmins.ID, threeAdmins.ID},
+			2: {threeAdmins.ID},
+			3: nil,
+		} {
+			got, err := db.RoomsWithManyAdmins(context.Background(), threshold)
+			if err != nil {
+				t.Fatalf("RoomsWithManyAdmins returned %s", err)
+			}
+			sort.Strings(got)
+			sort.Strings(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("threshold %d: got %v, want %v", threshold, got, want)
+			}
+		}
+	})
+}
+
+func TestRoomsBySizeBucket(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+
+		alice := test.NewUser()
+		withMembers := func(joined, left int) *test.Room {
+			room := test.NewRoom(t, alice, test.RoomPreset(test.PresetPublicChat))
+			for i := 0; i < joined+left; i++ {
+				user := test.NewUser()
+				room.CreateAndInsert(t, user, gomatrixserverlib.MRoomMember, map[string]interface{}{
+					"membership": "join",
+				}, test.WithStateKey(user.ID))
+				if i >= joined {
+					room.CreateAndInsert(t, user, gomatrixserverlib.MRoomMember, map[string]interface{}{
+						"membership": "leave",
+					}, test.WithStateKey(user.ID))
+				}
+			}
+			return room
+		}
+		for _, room := range []*test.Room{
+			withMembers(0, 0),
+			withMembers(0, 3),
+			withMembers(1, 0),
+			withMembers(9, 1),
+			withMembers(10, 0),
+		} {
+			mustStoreRoom(t, db, room)
+		}
+
+		got, err := db.RoomsBySizeBucket(context.Background())
+		if err != nil {
+			t.Fatalf("RoomsBySizeBucket returned %s", err)
+		}
+		want := map[string]int64{"1": 2, "2-10": 2, "11-100": 1}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestEphemeralRoomCount(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+
+		alice, bob := test.NewUser(), test.NewUser()
+		leftAfter := func(d time.Duration) *test.Room {
+			room := test.NewRoom(t, alice)
+			room.CreateAndInsert(t, alice, gomatrixserverlib.MRoomMember, map[string]interface{}{
+				"membership": "leave",
+			}, test.WithStateKey(alice.ID), test.WithTimestamp(time.Now().Add(d)))
+			return room
+		}
+		withBob := func() *test.Room {
+			room := test.NewRoom(t, alice)
+			room.CreateAndInsert(t, bob, gomatrixserverlib.MRoomMember, map[string]interface{}{
+				"membership": "join",
+			}, test.WithStateKey(bob.ID))
+			return room
+		}
+		// bob stays, so only the ban makes the room ephemeral
+		bannedAfter := func(d time.Duration) *test.Room {
+			room := withBob()
+			// bob can only ban alice once she has demoted herself below him
+			for _, aliceLevel := range []int64{100, 50} {
+				room.CreateAndInsert(t, alice, gomatrixserverlib.MRoomPowerLevels, map[string]interface{}{
+					"users": map[string]int64{alice.ID: aliceLevel, bob.ID: 100},
+				}, test.WithStateKey(""))
+			}
+			room.CreateAndInsert(t, bob, gomatrixserverlib.MRoomMember, map[string]interface{}{
+				"membership": "ban",
+			}, test.WithStateKey(alice.ID), test.WithTimestamp(time.Now().Add(d)))
+			return room
+		}
+		// alice is invited back after leaving, so only the room emptying
+		// when bob leaves makes it ephemeral
+		emptiedAfter := func(d time.Duration) *test.Room {
+			room := withBob()
+			room.CreateAndInsert(t, alice, gomatrixserverlib.MRoomPowerLevels, map[string]interface{}{
+				"users": map[string]int64{alice.ID: 100, bob.ID: 100},
+			}, test.WithStateKey(""))
+			room.CreateAndInsert(t, alice, gomatrixserverlib.MRoomMember, map[string]interface{}{
+				"membership": "leave",
+			}, test.WithStateKey(alice.ID), test.WithTimestamp(time.Now().Add(time.Second)))
+			room.CreateAndInsert(t, bob, gomatrixserverlib.MRoomMember, map[string]interface{}{
+				"membership": "invite",
+			}, test.WithStateKey(alice.ID), test.WithTimestamp(time.Now().Add(2*time.Second)))
+			room.CreateAndInsert(t, bob, gomatrixserverlib.MRoomMember, map[string]interface{}{
+				"membership": "leave",
+			}, test.WithStateKey(bob.ID), test.WithTimestamp(time.Now().Add(d)))
+			return room
+		}
+		for _, room := range []*test.Room{
+			leftAfter(time.Minute),
+			leftAfter(10 * time.Minute),
+			leftAfter(2 * time.Hour),
+			test.NewRoom(t, alice),
+			bannedAfter(5 * time.Minute),
+			withBob(),
+			emptiedAfter(3 * time.Hour),
+		} {
+			mustStoreRoom(t, db, room)
+		}
+
+		for within, want := range map[time.Duration]int64{
+			time.Second:    0,
+			time.Hour:      3,
+			24 * time.Hour: 5,
+		} {
+			got, err := db.EphemeralRoomCount(context.Background(), within)
+			if err != nil {
+				t.Fatalf("EphemeralRoomCount returned %s", err)
+			}
+			if got != want {
+				t.Fatalf("within %s: got %d ephemeral rooms, want %d", within, got, want)
+			}
+		}
+	})
+}
+
+func TestSpaceCount(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+
+		alice := test.NewUser()
+		for _, room := range []*test.Room{
+			test.NewRoom(t, alice, test.RoomType("m.space")),
+			test.NewRoom(t, alice, test.RoomType("m.space")),
+			test.NewRoom(t, alice, test.RoomType("org.example.custom")),
+			test.NewRoom(t, alice),
+		} {
+			mustStoreRoom(t, db, room)
+		}
+
+		count, err := db.SpaceCount(context.Background())
+		if err != nil {
+			t.Fatalf("SpaceCount returned %s", err)
+		}
+		if count != 2 {
+			t.Fatalf("expected 2 spaces, got %d", count)
+		}
+	})
+}
+
+func TestEncryptedRoomsByAlgorithm(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+
+		alice := test.NewUser()
+		encryptedRoom := func(algorithm string) *test.Room {
+			room := test.NewRoom(t, alice)
+			room.CreateAndInsert(t, alice, "m.room.encryption", map[string]interface{}{
+				"algorithm": algorithm,
+			}, test.WithStateKey(""))
+			return room
+		}
+		for _, room := range []*test.Room{
+			encryptedRoom("m.megolm.v1.aes-sha2"),
+			encryptedRoom("m.megolm.v1.aes-sha2"),
+			encryptedRoom("org.example.custom"),
+			test.NewRoom(t, alice),
+		} {
+			mustStoreRoom(t, db, room)
+		}
+
+		got, err := db.EncryptedRoomsByAlgorithm(context.Background())
+		if err != nil {
+			t.Fatalf("EncryptedRoomsByAlgorithm returned %s", err)
+		}
+		want := map[string]int64{
+			"m.megolm.v1.aes-sha2": 2,
+			"org.example.custom":   1,
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestRoomsWithServerACL(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+
+		alice := test.NewUser()
+		aclRoom := func(deny ...string) *test.Room {
+			room := test.NewRoom(t, alice)
+			room.CreateAndInsert(t, alice, "m.room.server_acl", map[string]interface{}{
+				"allow": []string{"*"},
+				"deny":  deny,
+			}, test.WithStateKey(""))
+			return room
+		}
+		for _, room := range []*test.Room{
+			aclRoom("evil.example.com"),
+			aclRoom(),
+			test.NewRoom(t, alice),
+		} {
+			mustStoreRoom(t, db, room)
+		}
+
+		count, err := db.RoomsWithServerACL(context.Background())
+		if err != nil {
+			t.Fatalf("RoomsWithServerACL returned %s", err)
+		}
+		if count != 2 {
+			t.Fatalf("expected 2 rooms with a server ACL, got %d", count)
+		}
+	})
+}
+
+func TestRedactedEventCount(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+
+		alice := test.NewUser()
+		room := test.NewRoom(t, alice)
+		now := time.Now()
+		for _, sentAgo := range []time.Duration{48 * time.Hour, 2 * time.Hour, time.Hour, 0} {
+			msg := room.CreateAndInsert(t, alice, "m.room.message", map[string]interface{}{
+				"msgtype": "m.text",
+				"body":    "hello",
+			}, test.WithTimestamp(now.Add(-sentAgo)))
+			room.CreateAndInsert(t, alice, gomatrixserverlib.MRoomRedaction, map[string]interface{}{}, test.WithRedacts(msg.EventID()), test.WithTimestamp(now.Add(-sentAgo)))
+		}
+		mustStoreRoom(t, db, room)
+
+		count, err := db.RedactedEventCount(context.Background(), now.Add(-24*time.Hour), now)
+		if err != nil {
+			t.Fatalf("RedactedEventCount returned %s", err)
+		}
+		if count != 2 {
+			t.Fatalf("expected 2 redactions in the window, got %d", count)
+		}
+	})
+}
+
+func TestEventsByDay(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+
+		alice := test.NewUser()
+		room := test.NewRoom(t, alice)
+		day := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+		for _, sentAt := range []time.Duration{time.Hour, 2 * time.Hour, 25 * time.Hour, 49 * time.Hour} {
+			room.CreateAndInsert(t, alice, "m.room.message", map[string]interface{}{
+				"msgtype": "m.text",
+				"body":    "hello",
+			}, test.WithTimestamp(day.Add(sentAt)))
+		}
+		mustStoreRoom(t, db, room)
+
+		// the room creation events are sent now, outside of the window
+		got, err := db.EventsByDay(context.Background(), day, day.Add(48*time.Hour))
+		if err != nil {
+			t.Fatalf("EventsByDay returned %s", err)
+		}
+		want := map[int64]int64{
+			int64(gomatrixserverlib.AsTimestamp(day)):                     2,
+			int64(gomatrixserverlib.AsTimestamp(day.Add(24 * time.Hour))): 1,
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestUsersByRoomVersion(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+
+		alice, bob := test.NewUser(), test.NewUser()
+		remote := &test.User{ID: "@charlie:remote"}
+		withMembers := func(roomVersion gomatrixserverlib.RoomVersion, members ...*test.User) *test.Room {
+			room := test.NewRoom(t, alice, test.RoomPreset(test.PresetPublicChat), test.RoomVersion(roomVersion))
+			for _, member := range members {
+				room.CreateAndInsert(t, member, gomatrixserverlib.MRoomMember, map[string]interface{}{
+					"membership": "join",
+				}, test.WithStateKey(member.ID))
+			}
+			return room
+		}
+		for _, room := range []*test.Room{
+			withMembers(gomatrixserverlib.RoomVersionV9, bob),
+			withMembers(gomatrixserverlib.RoomVersionV9, bob, remote),
+			withMembers(gomatrixserverlib.RoomVersionV6, remote),
+		} {
+			mustStoreRoom(t, db, room)
+		}
+
+		got, err := db.UsersByRoomVersion(context.Background())
+		if err != nil {
+			t.Fatalf("UsersByRoomVersion returned %s", err)
+		}
+		want := map[string]int64{
+			string(gomatrixserverlib.RoomVersionV9): 2,
+			string(gomatrixserverlib.RoomVersionV6): 1,
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestRoomFederationFanout(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+
+		alice := test.NewUser()
+		withRemoteMembers := func(userIDs ...string) *test.Room {
+			room := test.NewRoom(t, alice, test.RoomPreset(test.PresetPublicChat))
+			for _, userID := range userIDs {
+				room.CreateAndInsert(t, &test.User{ID: userID}, gomatrixserverlib.MRoomMember, map[string]interface{}{
+					"membership": "join",
+				}, test.WithStateKey(userID))
+			}
+			return room
+		}
+		localOnly := withRemoteMembers()
+		oneServer := withRemoteMembers("@bob:remote1", "@charlie:remote1")
+		threeServers := withRemoteMembers("@bob:remote1", "@bob:remote2", "@bob:remote3")
+		twoServers := withRemoteMembers("@bob:remote1", "@bob:remote2:8448")
+		for _, room := range []*test.Room{localOnly, oneServer, threeServers, twoServers} {
+			mustStoreRoom(t, db, room)
+		}
+
+		got, err := db.RoomFederationFanout(context.Background(), 2)
+		if err != nil {
+			t.Fatalf("RoomFederationFanout returned %s", err)
+		}
+		want := []types.RoomFanout{
+			{RoomID: threeServers.ID, RemoteServers: 3},
+			{RoomID: twoServers.ID, RemoteServers: 2},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestFederationReachPerUser(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+
+		alice, bob, charlie := test.NewUser(), test.NewUser(), test.NewUser()
+		withMembers := func(creator *test.User, userIDs ...string) *test.Room {
+			room := test.NewRoom(t, creator, test.RoomPreset(test.PresetPublicChat))
+			for _, userID := range userIDs {
+				room.CreateAndInsert(t, &test.User{ID: userID}, gomatrixserverlib.MRoomMember, map[string]interface{}{
+					"membership": "join",
+				}, test.WithStateKey(userID))
+			}
+			return room
+		}
+		// alice shares rooms with three remote servers, bob with two and
+		// charlie with one
+		for _, room := range []*test.Room{
+			withMembers(alice, "@dave:remote1", "@eve:remote2"),
+			withMembers(bob, alice.ID, "@dave:remote2", "@eve:remote3", "@frank:remote3"),
+			withMembers(charlie, "@dave:remote1"),
+		} {
+			mustStoreRoom(t, db, room)
+		}
+
+		got, err := db.FederationReachPerUser(context.Background(), 2)
+		if err != nil {
+			t.Fatalf("FederationReachPerUser returned %s", err)
+		}
+		localpart := func(user *test.User) string {
+			localpart, _, err := gomatrixserverlib.SplitID('@', user.ID)
+			if err != nil {
+				t.Fatalf("SplitID returned %s", err)
+			}
+			return localpart
+		}
+		want := []types.UserFederationReach{
+			{Localpart: localpart(alice), Servers: 3},
+			{Localpart: localpart(bob), Servers: 2},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestRoomStatistics(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+
+		alice, bob := test.NewUser(), test.NewUser()
+		remote := &test.User{ID: "@charlie:remote"}
+		day := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+		withMessage := func(sender *test.User, sentAt time.Time, members ...*test.User) *test.Room {
+			room := test.NewRoom(t, alice, test.RoomPreset(test.PresetPublicChat))
+			for _, member := range members {
+				room.CreateAndInsert(t, member, gomatrixserverlib.MRoomMember, map[string]interface{}{
+					"membership": "join",
+				}, test.WithStateKey(member.ID))
+			}
+			_, origin, err := gomatrixserverlib.SplitID('@', sender.ID)
+			if err != nil {
+				t.Fatalf("SplitID returned %s", err)
+			}
+			room.CreateAndInsert(t, sender, "m.room.message", map[string]interface{}{
+				"msgtype": "m.text",
+				"body":    "hello",
+			}, test.WithTimestamp(sentAt), test.WithOrigin(origin))
+			return room
+		}
+		// only the first room has a local message in the window, and the
+		// room creation events are sent now, outside of it
+		for _, room := range []*test.Room{
+			withMessage(alice, day.Add(time.Hour), bob),
+			withMessage(alice, day.Add(-time.Hour)),
+			withMessage(remote, day.Add(time.Hour), remote),
+		} {
+			mustStoreRoom(t, db, room)
+		}
+
+		got, err := db.RoomStatistics(context.Background(), "localhost", day, day.Add(24*time.Hour))
+		if err != nil {
+			t.Fatalf("RoomStatistics returned %s", err)
+		}
+		want := &types.RoomStatistics{
+			ActiveRooms:         1,
+			RoomsByLocalMembers: map[string]int64{"1": 2, "2-10": 1},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %+v, want %+v", got, want)
+		}
+	})
+}
+
+func TestEventVolumeBySender(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+
+		alice := test.NewUser()
+		remote := &test.User{ID: "@charlie:remote"}
+		day := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+		room := test.NewRoom(t, alice, test.RoomPreset(test.PresetPublicChat))
+		room.CreateAndInsert(t, remote, gomatrixserverlib.MRoomMember, map[string]interface{}{
+			"membership": "join",
+		}, test.WithStateKey(remote.ID), test.WithTimestamp(day.Add(-time.Hour)), test.WithOrigin("remote"))
+		for _, msg := range []struct {
+			sender *test.User
+			origin gomatrixserverlib.ServerName
+			sentAt time.Time
+		}{
+			{alice, "localhost", day.Add(time.Hour)},
+			{alice, "localhost", day.Add(2 * time.Hour)},
+			{alice, "localhost", day.Add(25 * time.Hour)},
+			{remote, "remote", day.Add(3 * time.Hour)},
+			{remote, "remote", day.Add(-2 * time.Hour)},
+		} {
+			room.CreateAndInsert(t, msg.sender, "m.room.message", map[string]interface{}{
+				"msgtype": "m.text",
+				"body":    "hello",
+			}, test.WithTimestamp(msg.sentAt), test.WithOrigin(msg.origin))
+		}
+		mustStoreRoom(t, db, room)
+
+		// the room creation events are sent now, outside of the window
+		local, remoteCount, err := db.EventVolumeBySender(context.Background(), "localhost", day, day.Add(24*time.Hour))
+		if err != nil {
+			t.Fatalf("EventVolumeBySender returned %s", err)
+		}
+		if local != 2 || remoteCount != 1 {
+			t.Fatalf("got %d local and %d remote events, want 2 and 1", local, remoteCount)
+		}
+	})
+}
+
+func TestAvgRoomsMessagedPerActiveUser(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+
+		alice := test.NewUser()
+		bob := test.NewUser()
+		remote := &test.User{ID: "@charlie:remote"}
+		join := map[string]interface{}{"membership": "join"}
+		message := map[string]interface{}{"msgtype": "m.text", "body": "hello"}
+		longAgo := time.Now().Add(-48 * time.Hour)
+
+		// alice creates and so sends events to both rooms, bob only to the
+		// first room within the window, and the remote user isn't local
+		room1 := test.NewRoom(t, alice, test.RoomPreset(test.PresetPublicChat))
+		room1.CreateAndInsert(t, bob, gomatrixserverlib.MRoomMember, join, test.WithStateKey(bob.ID))
+		room1.CreateAndInsert(t, bob, "m.room.message", message)
+		room2 := test.NewRoom(t, alice, test.RoomPreset(test.PresetPublicChat))
+		room2.CreateAndInsert(t, bob, gomatrixserverlib.MRoomMember, join, test.WithStateKey(bob.ID), test.WithTimestamp(longAgo))
+		room2.CreateAndInsert(t, bob, "m.room.message", message, test.WithTimestamp(longAgo))
+		room2.CreateAndInsert(t, remote, gomatrixserverlib.MRoomMember, join, test.WithStateKey(remote.ID), test.WithOrigin("remote"))
+		room2.CreateAndInsert(t, remote, "m.room.message", message, test.WithOrigin("remote"))
+		mustStoreRoom(t, db, room1)
+		mustStoreRoom(t, db, room2)
+
+		avg, err := db.AvgRoomsMessagedPerActiveUser(context.Background(), "localhost", 24*time.Hour)
+		if err != nil {
+			t.Fatalf("AvgRoomsMessagedPerActiveUser returned %s", err)
+		}
+		if avg != 1.5 {
+			t.Fatalf("got an average of %v rooms, want 1.5", avg)
+		}
+
+		avg, err = db.AvgRoomsMessagedPerActiveUser(context.Background(), "remote", time.Nanosecond)
+		if err != nil {
+			t.Fatalf("AvgRoomsMessagedPerActiveUser returned %s", err)
+		}
+		if avg != 0 {
+			t.Fatalf("got an average of %v rooms without active users, want 0", avg)
+		}
+	})
+}
@@ -19,6 +19,20 @@ type EventJSON interface {
 	// Insert the event JSON. On conflict, replace the event JSON with the new value (for redactions).
 	InsertEventJSON(ctx context.Context, tx *sql.Tx, eventNID types.EventNID, eventJSON []byte) error
 	BulkSelectEventJSON(ctx context.Context, tx *sql.Tx, eventNIDs []types.EventNID) ([]EventJSONPair, error)
+	// SelectAverageEventSizes returns the rooms with the largest mean event JSON size.
+	SelectAverageEventSizes(ctx context.Context, txn *sql.Tx, limit int) ([]types.RoomEventSize, error)
+	// SelectEventCountsByDay returns the number of events which aren't rejected per day since the epoch.
+	SelectEventCountsByDay(ctx context.Context, txn *sql.Tx, fromTS, toTS int64) (map[int64]int64, error)
+	// SelectActiveRoomCount returns the number of rooms with events which aren't rejected sent by users of the given server.
+	SelectActiveRoomCount(ctx context.Context, txn *sql.Tx, serverName gomatrixserverlib.ServerName, fromTS, toTS int64) (int64, error)
+	// SelectEventCountOfType returns the number of events of the given type which aren't rejected with an origin_server_ts within [fromTS, toTS).
+	SelectEventCountOfType(ctx context.Context, txn *sql.Tx, eventTypeNID types.EventTypeNID, fromTS, toTS int64) (int64, error)
+	// SelectEventCountsBySenderServer returns the number of events which aren't rejected with an origin_server_ts within
+	// [fromTS, toTS) sent by users of the given server and by users of other servers.
+	SelectEventCountsBySenderServer(ctx context.Context, txn *sql.Tx, serverName gomatrixserverlib.ServerName, fromTS, toTS int64) (local, remote int64, err error)
+	// SelectSenderRoomCounts returns the number of users of the given server who sent events which aren't rejected with an
+	// origin_server_ts within [fromTS, toTS), and the number of distinct rooms summed over those users.
+	SelectSenderRoomCounts(ctx context.Context, txn *sql.Tx, serverName gomatrixserverlib.ServerName, fromTS, toTS int64) (senders, senderRooms int64, err error)
 }
 
 type EventTypes interface {
@@ -133,6 +147,16 @@ type Membership interface {
 	UpdateForgetMembership(ctx context.Context, txn *sql.Tx, roomNID types.RoomNID, targetUserNID types.EventStateKeyNID, forget bool) error
 	SelectLocalServerInRoom(ctx context.Context, txn *sql.Tx, roomNID types.RoomNID) (bool, error)
 	SelectServerInRoom(ctx context.Context, txn *sql.Tx, roomNID types.RoomNID, serverName gomatrixserverlib.ServerName) (bool, error)
+	// SelectJoinedMemberCounts returns the number of joined members for every room with at least one joined member.
+	SelectJoinedMemberCounts(ctx context.Context, txn *sql.Tx) (map[types.RoomNID]int64, error)
+	// SelectLocalJoinedMemberCounts returns the number of joined local members for every room with at least one.
+	SelectLocalJoinedMemberCounts(ctx context.Context, txn *sql.Tx) (map[types.RoomNID]int64, error)
+	// SelectRoomFanout returns the rooms with the most distinct remote servers among their joined members.
+	SelectRoomFanout(ctx context.Context, txn *sql.Tx, limit int) ([]types.RoomFanout, error)
+	// SelectLocalUsersByRoomVersion returns the number of distinct local users joined to rooms of each room version.
+	SelectLocalUsersByRoomVersion(ctx context.Context, txn *sql.Tx) (map[gomatrixserverlib.RoomVersion]int64, error)
+	// SelectLocalUserFederationReach returns the local users joined to rooms with the most distinct remote servers.
+	SelectLocalUserFederationReach(ctx context.Context, txn *sql.Tx, limit int) ([]types.UserFederationReach, error)
 }
 
 type Published interface {
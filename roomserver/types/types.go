@@ -232,3 +232,33 @@ type RoomInfo struct {
 	StateSnapshotNID StateSnapshotNID
 	IsStub           bool
 }
+
+// RoomFanout is the number of distinct remote servers joined to a room, which
+// is the number of servers each event sent in the room has to be sent to.
+type RoomFanout struct {
+	RoomID        string
+	RemoteServers int64
+}
+
+// UserFederationReach is the number of distinct remote servers with members
+// joined to any of the rooms a local user is joined to.
+type UserFederationReach struct {
+	Localpart string
+	Servers   int64
+}
+
+// RoomStatistics describes how active the rooms of the local server are.
+type RoomStatistics struct {
+	// ActiveRooms is the number of rooms local users sent events in.
+	ActiveRooms int64
+	// RoomsByLocalMembers is the number of rooms in each joined local member
+	// count bucket, as reported by RoomsBySizeBucket.
+	RoomsByLocalMembers map[string]int64
+}
+
+// RoomEventSize is the mean size in bytes of the stored JSON of the events in
+// a room.
+type RoomEventSize struct {
+	RoomID   string
+	AvgBytes float64
+}
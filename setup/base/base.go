@@ -279,6 +279,8 @@ func (b *BaseDendrite) CreateAccountsDB() userdb.Database {
 		b.Cfg.UserAPI.OpenIDTokenLifetimeMS,
 		userapi.DefaultLoginTokenLifetime,
 		b.Cfg.Global.ServerNotices.LocalPart,
+		b.Cfg.UserAPI.UserAgentRules,
+		b.Cfg.UserAPI.ClientVersionPatterns,
 	)
 	if err != nil {
 		logrus.WithError(err).Panicf("failed to connect to accounts db")
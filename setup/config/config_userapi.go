@@ -1,6 +1,11 @@
 package config
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"fmt"
+	"regexp"
+
+	"golang.org/x/crypto/bcrypt"
+)
 
 type UserAPI struct {
 	Matrix *Global `yaml:"-"`
@@ -16,11 +21,37 @@ type UserAPI struct {
 	// Disable TLS validation on HTTPS calls to push gatways. NOT RECOMMENDED!
 	PushGatewayDisableTLSValidation bool `yaml:"push_gateway_disable_tls_validation"`
 
+	// The number of registrations within an hour above which a possible
+	// registration attack is reported. Zero disables the check.
+	RegistrationSpikeThreshold int64 `yaml:"registration_spike_threshold"`
+
+	// Rules to classify devices into platforms by their user agent, in
+	// order. They are tried before the built-in rules.
+	UserAgentRules []UserAgentRule `yaml:"user_agent_rules"`
+
+	// Patterns to match the versions of clients in their user agents, in
+	// order. They are tried before the built-in patterns.
+	ClientVersionPatterns []ClientVersionPattern `yaml:"client_version_patterns"`
+
 	// The Account database stores the login details and account information
 	// for local users. It is accessed by the UserAPI.
 	AccountDatabase DatabaseOptions `yaml:"account_database"`
 }
 
+// UserAgentRule classifies devices whose user agent contains Pattern,
+// ignoring case, as running on Platform.
+type UserAgentRule struct {
+	Pattern  string `yaml:"pattern"`
+	Platform string `yaml:"platform"`
+}
+
+// ClientVersionPattern matches the version of Client in its user agents. The
+// first capture group of the regular expression Pattern is the version.
+type ClientVersionPattern struct {
+	Client  string `yaml:"client"`
+	Pattern string `yaml:"pattern"`
+}
+
 const DefaultOpenIDTokenLifetimeMS = 3600000 // 60 minutes
 
 func (c *UserAPI) Defaults(generate bool) {
@@ -39,4 +70,15 @@ func (c *UserAPI) Verify(configErrs *ConfigErrors, isMonolith bool) {
 	checkURL(configErrs, "user_api.internal_api.connect", string(c.InternalAPI.Connect))
 	checkNotEmpty(configErrs, "user_api.account_database.connection_string", string(c.AccountDatabase.ConnectionString))
 	checkPositive(configErrs, "user_api.openid_token_lifetime_ms", c.OpenIDTokenLifetimeMS)
+	for i, rule := range c.UserAgentRules {
+		checkNotEmpty(configErrs, fmt.Sprintf("user_api.user_agent_rules[%d].pattern", i), rule.Pattern)
+		checkNotEmpty(configErrs, fmt.Sprintf("user_api.user_agent_rules[%d].platform", i), rule.Platform)
+	}
+	for i, pattern := range c.ClientVersionPatterns {
+		checkNotEmpty(configErrs, fmt.Sprintf("user_api.client_version_patterns[%d].client", i), pattern.Client)
+		key := fmt.Sprintf("user_api.client_version_patterns[%d].pattern", i)
+		if re, err := regexp.Compile(pattern.Pattern); err != nil || re.NumSubexp() == 0 {
+			configErrs.Add(fmt.Sprintf("invalid value for config key %q: %q must be a regular expression with a capture group", key, pattern.Pattern))
+		}
+	}
 }
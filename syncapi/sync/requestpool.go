@@ -53,6 +53,7 @@ type RequestPool struct {
 	streams  *streams.Streams
 	Notifier *notifier.Notifier
 	producer PresencePublisher
+	syncing  *syncPositions
 }
 
 type PresencePublisher interface {
@@ -67,9 +68,6 @@ func NewRequestPool(
 	streams *streams.Streams, notifier *notifier.Notifier,
 	producer PresencePublisher,
 ) *RequestPool {
-	prometheus.MustRegister(
-		activeSyncRequests, waitingSyncRequests,
-	)
 	rp := &RequestPool{
 		db:       db,
 		cfg:      cfg,
@@ -81,7 +79,14 @@ func NewRequestPool(
 		streams:  streams,
 		Notifier: notifier,
 		producer: producer,
+		syncing:  newSyncPositions(),
 	}
+	prometheus.MustRegister(
+		activeSyncRequests, waitingSyncRequests, activeSyncConnections,
+		newSyncPositionLag(rp.syncing, func() types.StreamPosition {
+			return notifier.CurrentPosition().PDUPosition
+		}),
+	)
 	go rp.cleanLastSeen()
 	go rp.cleanPresence(db, time.Minute*5)
 	return rp
@@ -204,6 +209,78 @@ var waitingSyncRequests = prometheus.NewGauge(
 	},
 )
 
+var activeSyncConnections = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Namespace: "dendrite",
+		Subsystem: "syncapi",
+		Name:      "active_sync_connections",
+		Help:      "The number of sync requests that are currently held open as long-polls",
+	},
+)
+
+// trackSyncConnection marks a sync request as being held open as a long-poll.
+// The returned function must be called once the request stops waiting.
+func trackSyncConnection() (done func()) {
+	activeSyncConnections.Inc()
+	return activeSyncConnections.Dec
+}
+
+// syncPositions tracks the PDU positions which in-flight incremental syncs
+// are catching up from.
+type syncPositions struct {
+	sync.Mutex
+	positions map[*types.SyncRequest]types.StreamPosition
+}
+
+func newSyncPositions() *syncPositions {
+	return &syncPositions{
+		positions: make(map[*types.SyncRequest]types.StreamPosition),
+	}
+}
+
+// track records the position a sync request is catching up from. The
+// returned function must be called once the request has been responded to.
+func (p *syncPositions) track(syncReq *types.SyncRequest) (done func()) {
+	p.Lock()
+	defer p.Unlock()
+	p.positions[syncReq] = syncReq.Since.PDUPosition
+	return func() {
+		p.Lock()
+		defer p.Unlock()
+		delete(p.positions, syncReq)
+	}
+}
+
+// lag returns how far behind the given position the slowest tracked sync
+// request is, or 0 if there are none.
+func (p *syncPositions) lag(current types.StreamPosition) types.StreamPosition {
+	p.Lock()
+	defer p.Unlock()
+	var lag types.StreamPosition
+	for _, pos := range p.positions {
+		if pos < current && current-pos > lag {
+			lag = current - pos
+		}
+	}
+	return lag
+}
+
+// newSyncPositionLag returns a gauge reporting how many PDU stream positions
+// the slowest in-flight incremental sync is behind the current position.
+func newSyncPositionLag(syncing *syncPositions, currentPos func() types.StreamPosition) prometheus.GaugeFunc {
+	return prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: "dendrite",
+			Subsystem: "syncapi",
+			Name:      "sync_position_lag",
+			Help:      "The number of PDU stream positions the slowest active incremental sync is behind the latest position",
+		},
+		func() float64 {
+			return float64(syncing.lag(currentPos()))
+		},
+	)
+}
+
 // OnIncomingSyncRequest is called when a client makes a /sync request. This function MUST be
 // called in a dedicated goroutine for this request. This function will block the goroutine
 // until a response is ready, or it times out.
@@ -226,6 +303,10 @@ func (rp *RequestPool) OnIncomingSyncRequest(req *http.Request, device *userapi.
 	activeSyncRequests.Inc()
 	defer activeSyncRequests.Dec()
 
+	if !syncReq.Since.IsEmpty() {
+		defer rp.syncing.track(syncReq)()
+	}
+
 	rp.updateLastSeen(req, device)
 	rp.updatePresence(rp.db, req.FormValue("set_presence"), device.UserID)
 
@@ -235,6 +316,10 @@ func (rp *RequestPool) OnIncomingSyncRequest(req *http.Request, device *userapi.
 	currentPos := rp.Notifier.CurrentPosition()
 
 	if !rp.shouldReturnImmediately(syncReq, currentPos) {
+		// the connection only counts as held open while waiting, not while
+		// the response is built afterwards
+		stopWaiting := trackSyncConnection()
+
 		timer := time.NewTimer(syncReq.Timeout) // case of timeout=0 is handled above
 		defer timer.Stop()
 
@@ -251,12 +336,15 @@ func (rp *RequestPool) OnIncomingSyncRequest(req *http.Request, device *userapi.
 
 		select {
 		case <-syncReq.Context.Done(): // Caller gave up
+			stopWaiting()
 			return giveup()
 
 		case <-timer.C: // Timeout reached
+			stopWaiting()
 			return giveup()
 
 		case <-userStreamListener.GetNotifyChannel(syncReq.Since):
+			stopWaiting()
 			syncReq.Log.Debugln("Responding to sync after wake-up")
 			currentPos.ApplyUpdates(userStreamListener.GetSyncPosition())
 		}
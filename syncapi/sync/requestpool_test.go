@@ -2,13 +2,20 @@ package sync
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/syncapi/notifier"
+	"github.com/matrix-org/dendrite/syncapi/streams"
 	"github.com/matrix-org/dendrite/syncapi/types"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
 	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/util"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 type dummyPublisher struct {
@@ -126,3 +133,131 @@ func TestRequestPool_updatePresence(t *testing.T) {
 		})
 	}
 }
+
+type dummyUserAPI struct {
+	userapi.UserInternalAPI
+}
+
+func (d *dummyUserAPI) PerformLastSeenUpdate(ctx context.Context, req *userapi.PerformLastSeenUpdateRequest, res *userapi.PerformLastSeenUpdateResponse) error {
+	return nil
+}
+
+// blockingStreamProvider returns from incremental syncs without any updates.
+// If building is set, it reports when an incremental sync starts and then
+// waits for release, so that a request can be observed while its response is
+// being built.
+type blockingStreamProvider struct {
+	types.StreamProvider
+	building chan struct{}
+	release  chan struct{}
+}
+
+func (p *blockingStreamProvider) IncrementalSync(ctx context.Context, req *types.SyncRequest, from, to types.StreamPosition) types.StreamPosition {
+	if p.building != nil {
+		p.building <- struct{}{}
+		<-p.release
+	}
+	return to
+}
+
+func TestActiveSyncConnections(t *testing.T) {
+	pdus := &blockingStreamProvider{building: make(chan struct{}), release: make(chan struct{})}
+	others := &blockingStreamProvider{}
+	since := types.StreamingToken{PDUPosition: 1, SendToDevicePosition: 1}
+	rp := &RequestPool{
+		cfg:      &config.SyncAPI{Matrix: &config.Global{}},
+		userAPI:  &dummyUserAPI{},
+		lastseen: &sync.Map{},
+		presence: &sync.Map{},
+		streams: &streams.Streams{
+			PDUStreamProvider:              pdus,
+			TypingStreamProvider:           others,
+			ReceiptStreamProvider:          others,
+			InviteStreamProvider:           others,
+			SendToDeviceStreamProvider:     others,
+			AccountDataStreamProvider:      others,
+			DeviceListStreamProvider:       others,
+			NotificationDataStreamProvider: others,
+			PresenceStreamProvider:         others,
+		},
+		Notifier: notifier.NewNotifier(),
+		syncing:  newSyncPositions(),
+	}
+	rp.Notifier.SetCurrentPosition(since)
+	device := &userapi.Device{UserID: "@alice:localhost", ID: "ALICE", SessionID: 1}
+	before := testutil.ToFloat64(activeSyncConnections)
+
+	// startSync starts a long-polling sync request which is up to date, so it
+	// waits for updates
+	startSync := func(ctx context.Context) <-chan util.JSONResponse {
+		req := httptest.NewRequest(http.MethodGet, "/sync?timeout=60000&since="+since.String(), nil).WithContext(ctx)
+		res := make(chan util.JSONResponse, 1)
+		go func() {
+			res <- rp.OnIncomingSyncRequest(req, device)
+		}()
+		return res
+	}
+	waitForConnections := func(want float64) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for testutil.ToFloat64(activeSyncConnections)-before != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %v open sync connections, got %v", want, testutil.ToFloat64(activeSyncConnections)-before)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// a request which is held open counts until the client gives up
+	ctx, cancel := context.WithCancel(context.Background())
+	parked := startSync(ctx)
+	waitForConnections(1)
+	cancel()
+	<-parked
+	waitForConnections(0)
+
+	// a request which is woken up stops counting before its response is built
+	returned := startSync(context.Background())
+	waitForConnections(1)
+	rp.Notifier.OnNewSendToDevice(device.UserID, []string{device.ID}, types.StreamingToken{SendToDevicePosition: 2})
+	<-pdus.building
+	if got := testutil.ToFloat64(activeSyncConnections) - before; got != 0 {
+		t.Fatalf("expected no open sync connections while building the response, got %v", got)
+	}
+	close(pdus.release)
+	if res := <-returned; res.Code != http.StatusOK {
+		t.Fatalf("expected the sync to return 200, got %d", res.Code)
+	}
+}
+
+func TestSyncPositionLag(t *testing.T) {
+	syncing := newSyncPositions()
+	current := types.StreamPosition(10)
+	gauge := newSyncPositionLag(syncing, func() types.StreamPosition {
+		return current
+	})
+	if got := testutil.ToFloat64(gauge); got != 0 {
+		t.Fatalf("expected no lag without active syncs, got %v", got)
+	}
+
+	lagging := syncing.track(&types.SyncRequest{Since: types.StreamingToken{PDUPosition: 4}})
+	upToDate := syncing.track(&types.SyncRequest{Since: types.StreamingToken{PDUPosition: 10}})
+	if got := testutil.ToFloat64(gauge); got != 6 {
+		t.Fatalf("expected a lag of 6, got %v", got)
+	}
+
+	current = 12
+	if got := testutil.ToFloat64(gauge); got != 8 {
+		t.Fatalf("expected a lag of 8 after the position advanced, got %v", got)
+	}
+
+	lagging()
+	if got := testutil.ToFloat64(gauge); got != 2 {
+		t.Fatalf("expected a lag of 2 once the lagging sync finished, got %v", got)
+	}
+
+	upToDate()
+	if got := testutil.ToFloat64(gauge); got != 0 {
+		t.Fatalf("expected no lag once all syncs finished, got %v", got)
+	}
+}
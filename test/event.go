@@ -28,6 +28,7 @@ type eventMods struct {
 	origin         gomatrixserverlib.ServerName
 	stateKey       *string
 	unsigned       interface{}
+	redacts        string
 	keyID          gomatrixserverlib.KeyID
 	privKey        ed25519.PrivateKey
 }
@@ -52,6 +53,18 @@ func WithUnsigned(unsigned interface{}) eventModifier {
 	}
 }
 
+func WithRedacts(eventID string) eventModifier {
+	return func(e *eventMods) {
+		e.redacts = eventID
+	}
+}
+
+func WithOrigin(origin gomatrixserverlib.ServerName) eventModifier {
+	return func(e *eventMods) {
+		e.origin = origin
+	}
+}
+
 // Reverse a list of events
 func Reversed(in []*gomatrixserverlib.HeaderedEvent) []*gomatrixserverlib.HeaderedEvent {
 	out := make([]*gomatrixserverlib.HeaderedEvent, len(in))
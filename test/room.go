@@ -48,6 +48,8 @@ type Room struct {
 	Version gomatrixserverlib.RoomVersion
 	preset  Preset
 	creator *User
+	// the type of the room, e.g. "m.space", or empty for a regular room
+	roomType string
 
 	authEvents gomatrixserverlib.AuthEvents
 	events     []*gomatrixserverlib.HeaderedEvent
@@ -88,10 +90,14 @@ func (r *Room) insertCreateEvents(t *testing.T) {
 		joinRule.JoinRule = "public"
 		hisVis.HistoryVisibility = "shared"
 	}
-	r.CreateAndInsert(t, r.creator, gomatrixserverlib.MRoomCreate, map[string]interface{}{
+	createContent := map[string]interface{}{
 		"creator":      r.creator.ID,
 		"room_version": r.Version,
-	}, WithStateKey(""))
+	}
+	if r.roomType != "" {
+		createContent["type"] = r.roomType
+	}
+	r.CreateAndInsert(t, r.creator, gomatrixserverlib.MRoomCreate, createContent, WithStateKey(""))
 	r.CreateAndInsert(t, r.creator, gomatrixserverlib.MRoomMember, map[string]interface{}{
 		"membership": "join",
 	}, WithStateKey(r.creator.ID))
@@ -140,6 +146,7 @@ func (r *Room) CreateEvent(t *testing.T, creator *User, eventType string, conten
 		StateKey: mod.stateKey,
 		Depth:    int64(depth),
 		Unsigned: unsigned,
+		Redacts:  mod.redacts,
 	}
 	err = builder.SetContent(content)
 	if err != nil {
@@ -221,3 +228,9 @@ func RoomVersion(ver gomatrixserverlib.RoomVersion) roomModifier {
 		r.Version = ver
 	}
 }
+
+func RoomType(roomType string) roomModifier {
+	return func(t *testing.T, r *Room) {
+		r.roomType = roomType
+	}
+}
@@ -292,9 +292,10 @@ type PerformAccountCreationRequest struct {
 	AccountType AccountType // Required: whether this is a guest or user account
 	Localpart   string      // Required: The localpart for this account. Ignored if account type is guest.
 
-	AppServiceID string // optional: the application service ID (not user ID) creating this account, if any.
-	Password     string // optional: if missing then this account will be a passwordless account
-	OnConflict   Conflict
+	AppServiceID     string // optional: the application service ID (not user ID) creating this account, if any.
+	Password         string // optional: if missing then this account will be a passwordless account
+	OnConflict       Conflict
+	RegistrationFlow string // optional: the registration flow used to create this account, e.g. "m.login.dummy"
 }
 
 // PerformAccountCreationResponse is the response for PerformAccountCreation
@@ -413,6 +414,28 @@ type Account struct {
 	// TODO: Associations (e.g. with application services)
 }
 
+// RegistrationBucket is the number of accounts registered within the hour
+// starting at Hour, in Unix epoch milliseconds.
+type RegistrationBucket struct {
+	Hour  int64
+	Count int64
+}
+
+// RegistrationSpike returns true if more than threshold accounts were
+// registered within any one of the buckets, which may be a registration
+// attack. A threshold of zero or less disables the check.
+func RegistrationSpike(buckets []RegistrationBucket, threshold int64) bool {
+	if threshold <= 0 {
+		return false
+	}
+	for _, bucket := range buckets {
+		if bucket.Count > threshold {
+			return true
+		}
+	}
+	return false
+}
+
 // OpenIDToken represents an OpenID token
 type OpenIDToken struct {
 	Token       string
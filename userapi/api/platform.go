@@ -0,0 +1,109 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/matrix-org/dendrite/setup/config"
+)
+
+// The platforms a device can be classified into by ClassifyUserAgent.
+const (
+	PlatformAndroid  = "android"
+	PlatformIOS      = "ios"
+	PlatformElectron = "electron"
+	PlatformWeb      = "web"
+	PlatformOther    = "other"
+)
+
+// DefaultUserAgentRules are the rules ClassifyUserAgent falls back to after
+// the configured ones. Desktop apps are checked first as Electron also reports itself
+// as a browser, and browsers before the native mobile apps, so that a browser
+// on a phone counts as web.
+var DefaultUserAgentRules = []config.UserAgentRule{
+	{Pattern: "electron", Platform: PlatformElectron},
+	{Pattern: "mozilla", Platform: PlatformWeb},
+	{Pattern: "gecko", Platform: PlatformWeb},
+	{Pattern: "android", Platform: PlatformAndroid},
+	{Pattern: "ios", Platform: PlatformIOS},
+	{Pattern: "iphone", Platform: PlatformIOS},
+	{Pattern: "ipad", Platform: PlatformIOS},
+}
+
+// ClassifyUserAgent guesses which platform a client runs on from the user
+// agent it last used, using the first rule whose pattern is found in the user
+// agent, ignoring case. The given rules are tried before DefaultUserAgentRules,
+// so they can recognise clients which the built-in rules would misclassify.
+// User agents which don't match any rule are classified as PlatformOther.
+func ClassifyUserAgent(userAgent string, rules []config.UserAgentRule) string {
+	userAgent = strings.ToLower(userAgent)
+	for _, ruleset := range [][]config.UserAgentRule{rules, DefaultUserAgentRules} {
+		for _, rule := range ruleset {
+			if strings.Contains(userAgent, strings.ToLower(rule.Pattern)) {
+				return rule.Platform
+			}
+		}
+	}
+	return PlatformOther
+}
+
+// ClientVersionPattern matches the version of Client in its user agents. The
+// first capture group of Pattern is the version.
+type ClientVersionPattern struct {
+	Client  string
+	Pattern *regexp.Regexp
+}
+
+// DefaultClientVersionPatterns match the versions of some well-known clients
+// in their user agents, here the major and minor version. ClientVersion falls
+// back to them after the configured patterns.
+var DefaultClientVersionPatterns = []ClientVersionPattern{
+	{Client: "Element", Pattern: regexp.MustCompile(`\bElement/(\d+\.\d+)`)},
+	{Client: "SchildiChat", Pattern: regexp.MustCompile(`\bSchildiChat/(\d+\.\d+)`)},
+	{Client: "FluffyChat", Pattern: regexp.MustCompile(`\bFluffyChat/(\d+\.\d+)`)},
+	{Client: "nheko", Pattern: regexp.MustCompile(`\bnheko/v?(\d+\.\d+)`)},
+}
+
+// CompileClientVersionPatterns compiles the configured client version
+// patterns, in order.
+func CompileClientVersionPatterns(patterns []config.ClientVersionPattern) ([]ClientVersionPattern, error) {
+	compiled := make([]ClientVersionPattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("client version pattern for %q: %w", pattern.Client, err)
+		}
+		compiled = append(compiled, ClientVersionPattern{Client: pattern.Client, Pattern: re})
+	}
+	return compiled, nil
+}
+
+// ClientVersion returns the client name and version, e.g. "Element/1.11",
+// from the first pattern which matches the user agent, or false if none of
+// them match. The given patterns are tried before DefaultClientVersionPatterns,
+// so they can recognise clients which the built-in patterns don't.
+func ClientVersion(userAgent string, patterns []ClientVersionPattern) (string, bool) {
+	for _, set := range [][]ClientVersionPattern{patterns, DefaultClientVersionPatterns} {
+		for _, pattern := range set {
+			if match := pattern.Pattern.FindStringSubmatch(userAgent); len(match) > 1 {
+				return pattern.Client + "/" + match[1], true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,68 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package userapi
+
+import (
+	"database/sql"
+
+	"github.com/matrix-org/dendrite/userapi/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// newDBStatsMetrics returns metrics reporting the connection pool statistics
+// of the user API database, so that a saturated pool shows up as queries
+// waiting for a connection rather than just as slow requests.
+func newDBStatsMetrics(db storage.Database) []prometheus.Collector {
+	opts := func(name, help string) prometheus.Opts {
+		return prometheus.Opts{
+			Namespace: "dendrite",
+			Subsystem: "userapi",
+			Name:      name,
+			Help:      help,
+		}
+	}
+	gauge := func(name, help string, value func(sql.DBStats) int) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts(opts(name, help)), func() float64 {
+			return float64(value(db.DBStats()))
+		})
+	}
+	return []prometheus.Collector{
+		gauge("db_open_connections", "Number of established connections to the user API database",
+			func(s sql.DBStats) int { return s.OpenConnections }),
+		gauge("db_in_use_connections", "Number of user API database connections currently in use",
+			func(s sql.DBStats) int { return s.InUse }),
+		gauge("db_idle_connections", "Number of idle user API database connections",
+			func(s sql.DBStats) int { return s.Idle }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts(opts("db_wait_count_total", "Total number of user API database queries which had to wait for a free connection")), func() float64 {
+			return float64(db.DBStats().WaitCount)
+		}),
+	}
+}
+
+// warnOnDBWaits runs the named stats collection and logs a warning if queries
+// had to wait for a free user API database connection while it ran, as the
+// stats queries then compete with requests for a saturated pool.
+func warnOnDBWaits(db storage.Database, name string, collect func() error) error {
+	before := db.DBStats().WaitCount
+	err := collect()
+	if waits := db.DBStats().WaitCount - before; waits > 0 {
+		logrus.WithFields(logrus.Fields{
+			"stats": name,
+			"waits": waits,
+		}).Warn("User API database connection pool was exhausted while collecting stats")
+	}
+	return err
+}
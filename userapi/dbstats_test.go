@@ -0,0 +1,163 @@
+package userapi
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/test"
+	"github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/dendrite/userapi/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// mustCreateSingleConnectionDB creates a user API database with a single
+// connection, so that concurrent queries have to wait for it.
+func mustCreateSingleConnectionDB(t *testing.T, dbType test.DBType) storage.Database {
+	t.Helper()
+	connStr, close := test.PrepareDBConnectionString(t, dbType)
+	t.Cleanup(close)
+	db, err := storage.NewDatabase(&config.DatabaseOptions{
+		ConnectionString:   config.DataSource(connStr),
+		MaxOpenConnections: 1,
+		MaxIdleConnections: 1,
+	}, "localhost", bcrypt.MinCost, config.DefaultOpenIDTokenLifetimeMS, api.DefaultLoginTokenLifetime*time.Millisecond, "", nil, nil)
+	if err != nil {
+		t.Fatalf("NewDatabase returned %s", err)
+	}
+	if _, err = db.CreateAccount(context.Background(), "alice", "", "", api.AccountTypeUser, ""); err != nil {
+		t.Fatalf("CreateAccount returned %s", err)
+	}
+	return db
+}
+
+// runConcurrentQueries runs queries concurrently until at least one of them
+// had to wait for a free connection.
+func runConcurrentQueries(t *testing.T, db storage.Database) {
+	t.Helper()
+	before := db.DBStats().WaitCount
+	for attempt := 0; attempt < 10 && db.DBStats().WaitCount == before; attempt++ {
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := db.GetAccountByLocalpart(context.Background(), "alice"); err != nil {
+					t.Errorf("GetAccountByLocalpart returned %s", err)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	if db.DBStats().WaitCount == before {
+		t.Fatalf("expected concurrent queries to wait for a connection")
+	}
+}
+
+func gatherMetrics(t *testing.T, gatherer prometheus.Gatherer) map[string]float64 {
+	t.Helper()
+	families, err := gatherer.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %s", err)
+	}
+	got := map[string]float64{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			switch {
+			case metric.GetGauge() != nil:
+				got[family.GetName()] = metric.GetGauge().GetValue()
+			case metric.GetCounter() != nil:
+				got[family.GetName()] = metric.GetCounter().GetValue()
+			}
+		}
+	}
+	return got
+}
+
+// dbStatsDatabase reports the given connection pool statistics.
+type dbStatsDatabase struct {
+	storage.Database
+	stats sql.DBStats
+}
+
+func (d *dbStatsDatabase) DBStats() sql.DBStats {
+	return d.stats
+}
+
+func TestDBStatsMetrics(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		if dbType == test.DBTypeSQLite {
+			t.Skip("SQLite connections aren't limited, so queries never wait")
+		}
+		db := mustCreateSingleConnectionDB(t, dbType)
+		runConcurrentQueries(t, db)
+
+		registry := prometheus.NewPedanticRegistry()
+		registry.MustRegister(newDBStatsMetrics(db)...)
+		got := gatherMetrics(t, registry)
+		want := map[string]float64{
+			"dendrite_userapi_db_open_connections":   1,
+			"dendrite_userapi_db_in_use_connections": 0,
+			"dendrite_userapi_db_idle_connections":   1,
+		}
+		for name, value := range want {
+			if v, ok := got[name]; !ok || v != value {
+				t.Errorf("expected %s to be %v, got %v (present: %v)", name, value, v, ok)
+			}
+		}
+		if v := got["dendrite_userapi_db_wait_count_total"]; v <= 0 {
+			t.Errorf("expected dendrite_userapi_db_wait_count_total to be greater than 0, got %v", v)
+		}
+	})
+}
+
+func TestDBStatsMetricsReregistered(t *testing.T) {
+	first := &dbStatsDatabase{stats: sql.DBStats{OpenConnections: 4, WaitCount: 5}}
+	registerCollectors(newDBStatsMetrics(first)...)
+
+	// a second NewInternalAPI call must replace the metrics of the first
+	// database rather than panicking or keeping them
+	second := &dbStatsDatabase{stats: sql.DBStats{OpenConnections: 1, WaitCount: 2}}
+	registerCollectors(newDBStatsMetrics(second)...)
+
+	got := gatherMetrics(t, prometheus.DefaultGatherer)
+	want := map[string]float64{
+		"dendrite_userapi_db_open_connections": 1,
+		"dendrite_userapi_db_wait_count_total": 2,
+	}
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("expected %s of the second database to be %v, got %v", name, value, got[name])
+		}
+	}
+}
+
+func TestWarnOnDBWaits(t *testing.T) {
+	db := &dbStatsDatabase{}
+	hook := logrustest.NewGlobal()
+	defer hook.Reset()
+
+	if err := warnOnDBWaits(db, "idle", func() error { return nil }); err != nil {
+		t.Fatalf("warnOnDBWaits returned %s", err)
+	}
+	if len(hook.AllEntries()) != 0 {
+		t.Fatalf("expected no warning without waits, got %+v", hook.AllEntries())
+	}
+
+	if err := warnOnDBWaits(db, "busy", func() error {
+		db.stats.WaitCount += 3
+		return nil
+	}); err != nil {
+		t.Fatalf("warnOnDBWaits returned %s", err)
+	}
+	entry := hook.LastEntry()
+	if entry == nil || entry.Level != logrus.WarnLevel || entry.Data["stats"] != "busy" || entry.Data["waits"] != int64(3) {
+		t.Fatalf("expected a warning for 3 waits during the busy stats, got %+v", entry)
+	}
+}
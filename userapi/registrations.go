@@ -0,0 +1,83 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package userapi
+
+import (
+	"context"
+	"time"
+
+	"github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/dendrite/userapi/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// registrationMetricsInterval is how often the registration metrics are
+// refreshed from the database, rather than querying it on every scrape.
+const registrationMetricsInterval = time.Minute
+
+var registrationsCurrentHour = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "dendrite",
+	Subsystem: "userapi",
+	Name:      "registrations_current_hour",
+	Help:      "Number of accounts registered in the current hour, excluding guests",
+})
+
+var registrationSpike = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "dendrite",
+	Subsystem: "userapi",
+	Name:      "registration_spike",
+	Help:      "Whether the registrations in the current hour exceed the configured threshold",
+})
+
+// startRegistrationMetrics registers the metrics reporting the number of
+// accounts registered in the current hour, and whether that exceeds the given
+// threshold so that a registration attack can be alerted on. They are
+// refreshed every registrationMetricsInterval until the context is done.
+func startRegistrationMetrics(ctx context.Context, db storage.Database, threshold int64) {
+	registerCollectors(registrationsCurrentHour, registrationSpike)
+	var refresh func()
+	refresh = func() {
+		if ctx.Err() != nil {
+			return
+		}
+		err := warnOnDBWaits(db, "registration metrics", func() error {
+			return updateRegistrationMetrics(ctx, db, threshold)
+		})
+		if err != nil {
+			logrus.WithError(err).Error("Failed to update registration metrics")
+		}
+		time.AfterFunc(registrationMetricsInterval, refresh)
+	}
+	go refresh()
+}
+
+func updateRegistrationMetrics(ctx context.Context, db storage.Database, threshold int64) error {
+	buckets, err := db.RegistrationVelocity(ctx, 1)
+	if err != nil {
+		return err
+	}
+	var count int64
+	for _, bucket := range buckets {
+		count += bucket.Count
+	}
+	registrationsCurrentHour.Set(float64(count))
+	if api.RegistrationSpike(buckets, threshold) {
+		registrationSpike.Set(1)
+	} else {
+		registrationSpike.Set(0)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package userapi
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/test"
+	"github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/dendrite/userapi/storage"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestRegistrationMetrics(t *testing.T) {
+	connStr, close := test.PrepareDBConnectionString(t, test.DBTypeSQLite)
+	defer close()
+	db, err := storage.NewDatabase(&config.DatabaseOptions{
+		ConnectionString: config.DataSource(connStr),
+	}, "localhost", bcrypt.MinCost, config.DefaultOpenIDTokenLifetimeMS, api.DefaultLoginTokenLifetime*time.Millisecond, "", nil, nil)
+	if err != nil {
+		t.Fatalf("NewDatabase returned %s", err)
+	}
+	ctx := context.Background()
+
+	assertMetrics := func(wantCount, wantSpike float64) {
+		t.Helper()
+		if err = updateRegistrationMetrics(ctx, db, 3); err != nil {
+			t.Fatalf("updateRegistrationMetrics returned %s", err)
+		}
+		if got := testutil.ToFloat64(registrationsCurrentHour); got != wantCount {
+			t.Errorf("expected %v registrations in the current hour, got %v", wantCount, got)
+		}
+		if got := testutil.ToFloat64(registrationSpike); got != wantSpike {
+			t.Errorf("expected the registration spike gauge to be %v, got %v", wantSpike, got)
+		}
+	}
+
+	registered := 0
+	register := func(n int) {
+		t.Helper()
+		for ; n > 0; n-- {
+			registered++
+			if _, err = db.CreateAccount(ctx, fmt.Sprintf("user%d", registered), "", "", api.AccountTypeUser, ""); err != nil {
+				t.Fatalf("CreateAccount returned %s", err)
+			}
+		}
+	}
+	register(3)
+	assertMetrics(3, 0)
+	register(1)
+	assertMetrics(4, 1)
+}
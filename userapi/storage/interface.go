@@ -16,8 +16,10 @@ package storage
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
+	"time"
 
 	"github.com/matrix-org/dendrite/clientapi/auth/authtypes"
 	"github.com/matrix-org/dendrite/userapi/api"
@@ -30,6 +32,9 @@ type Profile interface {
 	SetPassword(ctx context.Context, localpart string, plaintextPassword string) error
 	SetAvatarURL(ctx context.Context, localpart string, avatarURL string) error
 	SetDisplayName(ctx context.Context, localpart string, displayName string) error
+	// StaleProfileCount returns the number of profiles whose display name was never
+	// changed from the default set at registration.
+	StaleProfileCount(ctx context.Context) (int64, error)
 }
 
 type Database interface {
@@ -38,7 +43,7 @@ type Database interface {
 	// CreateAccount makes a new account with the given login name and password, and creates an empty profile
 	// for this account. If no password is supplied, the account will be a passwordless account. If the
 	// account already exists, it will return nil, ErrUserExists.
-	CreateAccount(ctx context.Context, localpart string, plaintextPassword string, appserviceID string, accountType api.AccountType) (*api.Account, error)
+	CreateAccount(ctx context.Context, localpart string, plaintextPassword string, appserviceID string, accountType api.AccountType, registrationFlow string) (*api.Account, error)
 	SaveAccountData(ctx context.Context, localpart, roomID, dataType string, content json.RawMessage) error
 	GetAccountData(ctx context.Context, localpart string) (global map[string]json.RawMessage, rooms map[string]map[string]json.RawMessage, err error)
 	// GetAccountDataByType returns account data matching a given
@@ -51,9 +56,29 @@ type Database interface {
 	RemoveThreePIDAssociation(ctx context.Context, threepid string, medium string) (err error)
 	GetLocalpartForThreePID(ctx context.Context, threepid string, medium string) (localpart string, err error)
 	GetThreePIDsForLocalpart(ctx context.Context, localpart string) (threepids []authtypes.ThreePID, err error)
+	// AvgThreePIDsPerAccount returns the average number of 3PIDs per active account.
+	AvgThreePIDsPerAccount(ctx context.Context) (float64, error)
+	// ThreePIDsByMedium returns the number of active accounts with a 3PID of each medium.
+	ThreePIDsByMedium(ctx context.Context) (map[string]int64, error)
 	CheckAccountAvailability(ctx context.Context, localpart string) (bool, error)
 	GetAccountByLocalpart(ctx context.Context, localpart string) (*api.Account, error)
 	DeactivateAccount(ctx context.Context, localpart string) (err error)
+	// RegistrationsByFlow returns the number of accounts created in [from, to) for each registration flow.
+	RegistrationsByFlow(ctx context.Context, from, to time.Time) (map[string]int64, error)
+	// RegistrationVelocity returns the number of accounts registered in each of the last given hours.
+	RegistrationVelocity(ctx context.Context, hours int) ([]api.RegistrationBucket, error)
+	// AppserviceCount returns the number of application services with at least one registered user.
+	AppserviceCount(ctx context.Context) (int64, error)
+	// AppserviceUserCounts returns the number of users registered by each application service.
+	AppserviceUserCounts(ctx context.Context) (map[string]int64, error)
+	// UsersByAccountType returns the number of active accounts of each account type.
+	UsersByAccountType(ctx context.Context) (map[string]int64, error)
+	// AccountsByHashAlgorithm returns the number of active accounts per password hash algorithm.
+	AccountsByHashAlgorithm(ctx context.Context) (map[string]int64, error)
+	// UsersInactiveSincePasswordReset returns the number of accounts which haven't used a device since changing their password.
+	UsersInactiveSincePasswordReset(ctx context.Context) (int64, error)
+	// UsersWithNoActiveDevices returns the number of accounts which haven't used any device within dormantFor.
+	UsersWithNoActiveDevices(ctx context.Context, dormantFor time.Duration) (int64, error)
 	CreateOpenIDToken(ctx context.Context, token, localpart string) (exp int64, err error)
 	GetOpenIDTokenAttributes(ctx context.Context, token string) (*api.OpenIDTokenAttributes, error)
 
@@ -65,6 +90,8 @@ type Database interface {
 	UpsertBackupKeys(ctx context.Context, version, userID string, uploads []api.InternalKeyBackupSession) (count int64, etag string, err error)
 	GetBackupKeys(ctx context.Context, version, userID, filterRoomID, filterSessionID string) (result map[string]map[string]api.KeyBackupSession, err error)
 	CountBackupKeys(ctx context.Context, version, userID string) (count int64, err error)
+	// KeyBackupAdoption returns the number of users with a key backup and the number of active accounts.
+	KeyBackupAdoption(ctx context.Context) (withBackup, total int64, err error)
 
 	GetDeviceByAccessToken(ctx context.Context, token string) (*api.Device, error)
 	GetDeviceByID(ctx context.Context, localpart, deviceID string) (*api.Device, error)
@@ -79,6 +106,22 @@ type Database interface {
 	CreateDevice(ctx context.Context, localpart string, deviceID *string, accessToken string, displayName *string, ipAddr, userAgent string) (dev *api.Device, returnErr error)
 	UpdateDevice(ctx context.Context, localpart, deviceID string, displayName *string) error
 	UpdateDeviceLastSeen(ctx context.Context, localpart, deviceID, ipAddr string) error
+	// LastSeenPlatform returns the platform and last seen time of the user's most recently used device.
+	LastSeenPlatform(ctx context.Context, localpart string) (platform string, lastSeen time.Time, err error)
+	// DevicesWithCustomNames returns the number of devices with a custom display name and the total number of devices.
+	DevicesWithCustomNames(ctx context.Context) (named, total int64, err error)
+	// AvgDevicesAddedPerMonth returns the average number of devices added per user per month over the last given months.
+	AvgDevicesAddedPerMonth(ctx context.Context, months int) (float64, error)
+	// DailyActiveIPs returns the number of distinct IP addresses devices were last seen from in the past day.
+	DailyActiveIPs(ctx context.Context) (int64, error)
+	// MonthlyActiveIPs returns the number of distinct IP addresses devices were last seen from in the past 30 days.
+	MonthlyActiveIPs(ctx context.Context) (int64, error)
+	// AvgUserAgentsPerUser returns the average number of distinct user agents per user with devices.
+	AvgUserAgentsPerUser(ctx context.Context) (float64, error)
+	// RetentionCohort returns the number of accounts registered in the given window and how many were seen retentionDays later.
+	RetentionCohort(ctx context.Context, registeredBetween [2]time.Time, retentionDays int) (registered, retained int64, err error)
+	// ActiveDevicesByClientVersion returns the number of devices seen since the given time per client version.
+	ActiveDevicesByClientVersion(ctx context.Context, since time.Time) (map[string]int64, error)
 	RemoveDevice(ctx context.Context, deviceID, localpart string) error
 	RemoveDevices(ctx context.Context, localpart string, devices []string) error
 	// RemoveAllDevices deleted all devices for this user. Returns the devices deleted.
@@ -101,12 +144,23 @@ type Database interface {
 	GetNotifications(ctx context.Context, localpart string, fromID int64, limit int, filter tables.NotificationFilter) ([]*api.Notification, int64, error)
 	GetNotificationCount(ctx context.Context, localpart string, filter tables.NotificationFilter) (int64, error)
 	GetRoomNotificationCounts(ctx context.Context, localpart, roomID string) (total int64, highlight int64, _ error)
+	// NotificationReadRatio returns the number of read and unread notifications across all users.
+	NotificationReadRatio(ctx context.Context) (read, unread int64, err error)
 	DeleteOldNotifications(ctx context.Context) error
 
 	UpsertPusher(ctx context.Context, p api.Pusher, localpart string) error
 	GetPushers(ctx context.Context, localpart string) ([]api.Pusher, error)
 	RemovePusher(ctx context.Context, appid, pushkey, localpart string) error
 	RemovePushers(ctx context.Context, appid, pushkey string) error
+	// NotificationOptInStats returns the number of active accounts with and without a pusher.
+	NotificationOptInStats(ctx context.Context) (withPushers, withoutPushers int64, err error)
+
+	// StatsCounters returns the running account counters, e.g. tables.StatsCounterRegistrations,
+	// which are updated as accounts are created and deactivated.
+	StatsCounters(ctx context.Context) (map[string]int64, error)
+
+	// DBStats returns the connection pool statistics of the database handle.
+	DBStats() sql.DBStats
 }
 
 // Err3PIDInUse is the error returned when trying to save an association involving
@@ -0,0 +1,57 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deltas
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/userapi/api"
+)
+
+func LoadSeedStatsCounters(m *sqlutil.Migrations) {
+	m.AddMigration(UpSeedStatsCounters, DownSeedStatsCounters)
+}
+
+// UpSeedStatsCounters starts the running registration and deactivation
+// counters from the existing accounts, as they are only incremented for
+// accounts created or deactivated after the counters were added. Guest
+// accounts aren't counted as registrations.
+func UpSeedStatsCounters(tx *sql.Tx) error {
+	_, err := tx.Exec(fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS userapi_stats_counters (
+	name TEXT NOT NULL PRIMARY KEY,
+	value BIGINT NOT NULL
+);
+INSERT INTO userapi_stats_counters (name, value)
+	SELECT 'registrations', COUNT(*) FROM account_accounts WHERE account_type != %d
+	ON CONFLICT (name) DO NOTHING;
+INSERT INTO userapi_stats_counters (name, value)
+	SELECT 'deactivations', COUNT(*) FROM account_accounts WHERE is_deactivated = TRUE
+	ON CONFLICT (name) DO NOTHING;`, api.AccountTypeGuest))
+	if err != nil {
+		return fmt.Errorf("failed to execute upgrade: %w", err)
+	}
+	return nil
+}
+
+func DownSeedStatsCounters(tx *sql.Tx) error {
+	_, err := tx.Exec(`DELETE FROM userapi_stats_counters WHERE name IN ('registrations', 'deactivations');`)
+	if err != nil {
+		return fmt.Errorf("failed to execute downgrade: %w", err)
+	}
+	return nil
+}
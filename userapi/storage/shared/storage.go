@@ -32,6 +32,7 @@ import (
 	"github.com/matrix-org/dendrite/clientapi/auth/authtypes"
 	"github.com/matrix-org/dendrite/internal/pushrules"
 	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/setup/config"
 	"github.com/matrix-org/dendrite/userapi/api"
 	"github.com/matrix-org/dendrite/userapi/storage/tables"
 )
@@ -51,10 +52,13 @@ type Database struct {
 	LoginTokens           tables.LoginTokenTable
 	Notifications         tables.NotificationTable
 	Pushers               tables.PusherTable
+	Counters              tables.StatsCountersTable
 	LoginTokenLifetime    time.Duration
 	ServerName            gomatrixserverlib.ServerName
 	BcryptCost            int
 	OpenIDTokenLifetimeMS int64
+	UserAgentRules        []config.UserAgentRule
+	ClientVersionPatterns []api.ClientVersionPattern
 }
 
 const (
@@ -121,9 +125,11 @@ func (d *Database) SetPassword(
 
 // CreateAccount makes a new account with the given login name and password, and creates an empty profile
 // for this account. If no password is supplied, the account will be a passwordless account. If the
-// account already exists, it will return nil, ErrUserExists.
+// account already exists, it will return nil, ErrUserExists. The registration flow, e.g. "m.login.dummy",
+// is recorded for accounts created through registration and can be empty otherwise.
 func (d *Database) CreateAccount(
 	ctx context.Context, localpart, plaintextPassword, appserviceID string, accountType api.AccountType,
+	registrationFlow string,
 ) (acc *api.Account, err error) {
 	err = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
 		// For guest accounts, we create a new numeric local part
@@ -137,7 +143,7 @@ func (d *Database) CreateAccount(
 			plaintextPassword = ""
 			appserviceID = ""
 		}
-		acc, err = d.createAccount(ctx, txn, localpart, plaintextPassword, appserviceID, accountType)
+		acc, err = d.createAccount(ctx, txn, localpart, plaintextPassword, appserviceID, accountType, registrationFlow)
 		return err
 	})
 	return
@@ -147,6 +153,7 @@ func (d *Database) CreateAccount(
 // been taken out by the caller (e.g. CreateAccount or CreateGuestAccount).
 func (d *Database) createAccount(
 	ctx context.Context, txn *sql.Tx, localpart, plaintextPassword, appserviceID string, accountType api.AccountType,
+	registrationFlow string,
 ) (*api.Account, error) {
 	var err error
 	var account *api.Account
@@ -158,12 +165,18 @@ func (d *Database) createAccount(
 			return nil, err
 		}
 	}
-	if account, err = d.Accounts.InsertAccount(ctx, txn, localpart, hash, appserviceID, accountType); err != nil {
+	if account, err = d.Accounts.InsertAccount(ctx, txn, localpart, hash, appserviceID, accountType, registrationFlow); err != nil {
 		return nil, sqlutil.ErrUserExists
 	}
 	if err = d.Profiles.InsertProfile(ctx, txn, localpart); err != nil {
 		return nil, err
 	}
+	// guests aren't counted, to match RegistrationVelocity and RegistrationsByFlow
+	if accountType != api.AccountTypeGuest {
+		if err = d.Counters.IncrementCounter(ctx, txn, tables.StatsCounterRegistrations, 1); err != nil {
+			return nil, err
+		}
+	}
 	pushRuleSets := pushrules.DefaultAccountRuleSets(localpart, d.ServerName)
 	prbs, err := json.Marshal(pushRuleSets)
 	if err != nil {
@@ -314,13 +327,242 @@ func (d *Database) SearchProfiles(ctx context.Context, searchString string, limi
 	return d.Profiles.SelectProfilesBySearch(ctx, searchString, limit)
 }
 
+// StaleProfileCount returns the number of profiles which have no display name,
+// or still have the localpart as their display name.
+func (d *Database) StaleProfileCount(ctx context.Context) (int64, error) {
+	return d.Profiles.SelectStaleProfileCount(ctx, nil)
+}
+
 // DeactivateAccount deactivates the user's account, removing all ability for the user to login again.
 func (d *Database) DeactivateAccount(ctx context.Context, localpart string) (err error) {
-	return d.Writer.Do(nil, nil, func(txn *sql.Tx) error {
-		return d.Accounts.DeactivateAccount(ctx, localpart)
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		deactivated, err := d.Accounts.DeactivateAccount(ctx, txn, localpart)
+		if err != nil || !deactivated {
+			return err
+		}
+		return d.Counters.IncrementCounter(ctx, txn, tables.StatsCounterDeactivations, 1)
 	})
 }
 
+// StatsCounters returns the current values of the running account counters,
+// keyed by counter name. The counters are seeded from the existing accounts
+// when they are first created, and guest accounts aren't counted as
+// registrations.
+func (d *Database) StatsCounters(ctx context.Context) (map[string]int64, error) {
+	return d.Counters.SelectCounters(ctx, nil)
+}
+
+// UsersInactiveSincePasswordReset returns the number of active accounts which
+// changed their password and haven't used any of their devices since.
+func (d *Database) UsersInactiveSincePasswordReset(ctx context.Context) (int64, error) {
+	return d.Devices.SelectInactiveSincePasswordChangeCount(ctx, nil)
+}
+
+// UsersWithNoActiveDevices returns the number of active accounts none of whose
+// devices have been used within dormantFor, including accounts which have no
+// devices at all.
+func (d *Database) UsersWithNoActiveDevices(ctx context.Context, dormantFor time.Duration) (int64, error) {
+	cutoff := gomatrixserverlib.AsTimestamp(time.Now().Add(-dormantFor))
+	return d.Devices.SelectDormantAccountCount(ctx, nil, int64(cutoff))
+}
+
+// RegistrationsByFlow returns the number of accounts created in [from, to)
+// for each registration flow. Accounts created before the flow was recorded,
+// or without going through a registration flow, such as with the
+// create-account tool, are counted as "unknown". Guest accounts aren't
+// counted.
+func (d *Database) RegistrationsByFlow(ctx context.Context, from, to time.Time) (map[string]int64, error) {
+	counts, err := d.Accounts.SelectRegistrationFlowCounts(
+		ctx, nil, int64(gomatrixserverlib.AsTimestamp(from)), int64(gomatrixserverlib.AsTimestamp(to)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if count, ok := counts[""]; ok {
+		delete(counts, "")
+		counts["unknown"] += count
+	}
+	return counts, nil
+}
+
+// RegistrationVelocity returns the number of accounts registered in each of
+// the last given number of hours, oldest first and including hours without
+// any registrations. The last bucket is the current, partial hour. Guest
+// accounts aren't counted.
+func (d *Database) RegistrationVelocity(ctx context.Context, hours int) ([]api.RegistrationBucket, error) {
+	if hours <= 0 {
+		return nil, fmt.Errorf("hours must be positive, got %d", hours)
+	}
+	from := time.Now().Truncate(time.Hour).Add(-time.Duration(hours-1) * time.Hour)
+	fromMS := int64(gomatrixserverlib.AsTimestamp(from))
+	counts, err := d.Accounts.SelectRegistrationCountsByHour(ctx, nil, fromMS)
+	if err != nil {
+		return nil, fmt.Errorf("d.Accounts.SelectRegistrationCountsByHour: %w", err)
+	}
+	hourMS := int64(time.Hour / time.Millisecond)
+	buckets := make([]api.RegistrationBucket, hours)
+	for i := range buckets {
+		hour := fromMS/hourMS + int64(i)
+		buckets[i] = api.RegistrationBucket{Hour: hour * hourMS, Count: counts[hour]}
+	}
+	return buckets, nil
+}
+
+// AppserviceCount returns the number of application services which have at
+// least one active registered user.
+func (d *Database) AppserviceCount(ctx context.Context) (int64, error) {
+	userCounts, err := d.Accounts.SelectAppserviceUserCounts(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(userCounts)), nil
+}
+
+// AppserviceUserCounts returns the number of active users registered by each
+// application service, keyed by application service ID.
+func (d *Database) AppserviceUserCounts(ctx context.Context) (map[string]int64, error) {
+	return d.Accounts.SelectAppserviceUserCounts(ctx, nil)
+}
+
+// accountTypeNames are the keys UsersByAccountType reports account types as.
+var accountTypeNames = map[api.AccountType]string{
+	api.AccountTypeUser:       "user",
+	api.AccountTypeGuest:      "guest",
+	api.AccountTypeAdmin:      "admin",
+	api.AccountTypeAppService: "appservice",
+}
+
+// UsersByAccountType returns the number of active accounts of each account
+// type, keyed by "user", "guest", "admin" or "appservice". Account types this
+// server doesn't know about are counted as "unknown".
+func (d *Database) UsersByAccountType(ctx context.Context) (map[string]int64, error) {
+	counts, err := d.Accounts.SelectAccountTypeCounts(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("d.Accounts.SelectAccountTypeCounts: %w", err)
+	}
+	result := make(map[string]int64, len(counts))
+	for accountType, count := range counts {
+		name, ok := accountTypeNames[accountType]
+		if !ok {
+			name = "unknown"
+		}
+		result[name] += count
+	}
+	return result, nil
+}
+
+// AccountsByHashAlgorithm returns the number of active accounts for each
+// password hash algorithm, e.g. "bcrypt/10" for bcrypt with a cost of 10.
+// Passwordless accounts are counted as "none" and hashes which aren't
+// recognised as "unknown".
+func (d *Database) AccountsByHashAlgorithm(ctx context.Context) (map[string]int64, error) {
+	prefixes, err := d.Accounts.SelectPasswordHashPrefixCounts(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("d.Accounts.SelectPasswordHashPrefixCounts: %w", err)
+	}
+	result := make(map[string]int64)
+	for prefix, count := range prefixes {
+		result[hashAlgorithm(prefix)] += count
+	}
+	return result, nil
+}
+
+// hashAlgorithm identifies the algorithm of a password hash from its prefix,
+// which is in the modular crypt format, e.g. "$2a$10$" for bcrypt.
+func hashAlgorithm(prefix string) string {
+	if prefix == "" {
+		return "none"
+	}
+	parts := strings.Split(prefix, "$")
+	if len(parts) < 3 || parts[0] != "" {
+		return "unknown"
+	}
+	switch parts[1] {
+	case "2", "2a", "2b", "2x", "2y":
+		if cost, err := strconv.Atoi(parts[2]); err == nil {
+			return fmt.Sprintf("bcrypt/%d", cost)
+		}
+	}
+	return "unknown"
+}
+
+// KeyBackupAdoption returns the number of users with a key backup which hasn't
+// been deleted, along with the number of active user and admin accounts.
+func (d *Database) KeyBackupAdoption(ctx context.Context) (withBackup, total int64, err error) {
+	if withBackup, err = d.KeyBackupVersions.SelectUsersWithKeyBackupCount(ctx, nil); err != nil {
+		return 0, 0, fmt.Errorf("d.KeyBackupVersions.SelectUsersWithKeyBackupCount: %w", err)
+	}
+	if total, err = d.Accounts.SelectActiveAccountCount(ctx, nil); err != nil {
+		return 0, 0, fmt.Errorf("d.Accounts.SelectActiveAccountCount: %w", err)
+	}
+	return withBackup, total, nil
+}
+
+// NotificationOptInStats returns the number of active user and admin accounts
+// with at least one pusher, i.e. which receive push notifications, and the
+// number of those without any.
+func (d *Database) NotificationOptInStats(ctx context.Context) (withPushers, withoutPushers int64, err error) {
+	if withPushers, err = d.Pushers.SelectUsersWithPushersCount(ctx, nil); err != nil {
+		return 0, 0, fmt.Errorf("d.Pushers.SelectUsersWithPushersCount: %w", err)
+	}
+	total, err := d.Accounts.SelectActiveAccountCount(ctx, nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("d.Accounts.SelectActiveAccountCount: %w", err)
+	}
+	return withPushers, total - withPushers, nil
+}
+
+// AvgDevicesAddedPerMonth returns the average number of devices each active
+// user or admin account added per month over the last given number of
+// months, where a month is 30 days.
+func (d *Database) AvgDevicesAddedPerMonth(ctx context.Context, months int) (float64, error) {
+	if months <= 0 {
+		return 0, fmt.Errorf("months must be positive, got %d", months)
+	}
+	since := time.Now().Add(-time.Duration(months) * 30 * 24 * time.Hour)
+	added, err := d.Devices.SelectDevicesCreatedSinceCount(ctx, nil, int64(gomatrixserverlib.AsTimestamp(since)))
+	if err != nil {
+		return 0, fmt.Errorf("d.Devices.SelectDevicesCreatedSinceCount: %w", err)
+	}
+	users, err := d.Accounts.SelectActiveAccountCount(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("d.Accounts.SelectActiveAccountCount: %w", err)
+	}
+	if users == 0 {
+		return 0, nil
+	}
+	return float64(added) / float64(users) / float64(months), nil
+}
+
+// AvgThreePIDsPerAccount returns the average number of 3PIDs associated with
+// each active user or admin account, including accounts without any.
+func (d *Database) AvgThreePIDsPerAccount(ctx context.Context) (float64, error) {
+	threePIDs, err := d.ThreePIDs.SelectActiveAccountThreePIDCount(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("d.ThreePIDs.SelectActiveAccountThreePIDCount: %w", err)
+	}
+	accounts, err := d.Accounts.SelectActiveAccountCount(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("d.Accounts.SelectActiveAccountCount: %w", err)
+	}
+	if accounts == 0 {
+		return 0, nil
+	}
+	return float64(threePIDs) / float64(accounts), nil
+}
+
+// ThreePIDsByMedium returns the number of active user and admin accounts with
+// at least one 3PID of each medium, e.g. "email" or "msisdn". 3PIDs are only
+// stored once they have been validated, so these are all verified.
+func (d *Database) ThreePIDsByMedium(ctx context.Context) (map[string]int64, error) {
+	return d.ThreePIDs.SelectThreePIDMediumCounts(ctx, nil)
+}
+
+// DBStats returns the connection pool statistics of the underlying database handle.
+func (d *Database) DBStats() sql.DBStats {
+	return d.DB.Stats()
+}
+
 // CreateOpenIDToken persists a new token that was issued for OpenID Connect
 func (d *Database) CreateOpenIDToken(
 	ctx context.Context,
@@ -633,6 +875,106 @@ func (d *Database) UpdateDeviceLastSeen(ctx context.Context, localpart, deviceID
 	})
 }
 
+// LastSeenPlatform returns the platform of the user's most recently used
+// device, as classified by api.ClassifyUserAgent with the configured rules,
+// along with when that device was last seen. Returns sql.ErrNoRows if the user
+// has no devices.
+func (d *Database) LastSeenPlatform(ctx context.Context, localpart string) (string, time.Time, error) {
+	devices, err := d.Devices.SelectDevicesByLocalpart(ctx, nil, localpart, "")
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if len(devices) == 0 {
+		return "", time.Time{}, sql.ErrNoRows
+	}
+	latest := devices[0]
+	for _, dev := range devices[1:] {
+		if dev.LastSeenTS > latest.LastSeenTS {
+			latest = dev
+		}
+	}
+	return api.ClassifyUserAgent(latest.UserAgent, d.UserAgentRules), gomatrixserverlib.Timestamp(latest.LastSeenTS).Time(), nil
+}
+
+// DevicesWithCustomNames returns the number of devices which have been given
+// a display name, along with the total number of devices. Devices without a
+// display name or whose display name is just their device ID aren't counted
+// as named.
+func (d *Database) DevicesWithCustomNames(ctx context.Context) (named, total int64, err error) {
+	return d.Devices.SelectNamedDeviceCounts(ctx, nil)
+}
+
+// DailyActiveIPs returns the number of distinct IP addresses which devices
+// were last seen from in the past day. Comparing this to the number of
+// active users helps to spot many accounts being used from shared IPs.
+func (d *Database) DailyActiveIPs(ctx context.Context) (int64, error) {
+	return d.activeIPsSince(ctx, time.Now().Add(-24*time.Hour))
+}
+
+// MonthlyActiveIPs returns the number of distinct IP addresses which devices
+// were last seen from in the past 30 days.
+func (d *Database) MonthlyActiveIPs(ctx context.Context) (int64, error) {
+	return d.activeIPsSince(ctx, time.Now().Add(-30*24*time.Hour))
+}
+
+func (d *Database) activeIPsSince(ctx context.Context, since time.Time) (int64, error) {
+	return d.Devices.SelectDistinctIPCountSince(ctx, nil, int64(gomatrixserverlib.AsTimestamp(since)))
+}
+
+// AvgUserAgentsPerUser returns the average number of distinct user agents
+// across the devices of each user, as a proxy for how many different clients
+// users run. Only users with at least one device with a user agent are
+// counted.
+func (d *Database) AvgUserAgentsPerUser(ctx context.Context) (float64, error) {
+	userAgents, users, err := d.Devices.SelectUserAgentDiversity(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	if users == 0 {
+		return 0, nil
+	}
+	return float64(userAgents) / float64(users), nil
+}
+
+// RetentionCohort returns the number of user and admin accounts registered in
+// [registeredBetween[0], registeredBetween[1]), and how many of those still
+// had a device in use at least retentionDays days after registering. As only
+// the last time each device was seen is known, a user who came back after
+// retentionDays but deleted that device since isn't counted as retained.
+func (d *Database) RetentionCohort(ctx context.Context, registeredBetween [2]time.Time, retentionDays int) (registered, retained int64, err error) {
+	if retentionDays <= 0 {
+		return 0, 0, fmt.Errorf("retentionDays must be positive, got %d", retentionDays)
+	}
+	registered, retained, err = d.Devices.SelectRetentionCohortCounts(
+		ctx, nil,
+		int64(gomatrixserverlib.AsTimestamp(registeredBetween[0])),
+		int64(gomatrixserverlib.AsTimestamp(registeredBetween[1])),
+		int64(time.Duration(retentionDays)*24*time.Hour/time.Millisecond),
+	)
+	if err != nil {
+		return 0, 0, fmt.Errorf("d.Devices.SelectRetentionCohortCounts: %w", err)
+	}
+	return registered, retained, nil
+}
+
+// ActiveDevicesByClientVersion returns the number of devices seen since the
+// given time for each client version, as matched by api.ClientVersion using
+// the configured patterns. Devices whose user agent matches none of the
+// patterns are not counted.
+func (d *Database) ActiveDevicesByClientVersion(ctx context.Context, since time.Time) (map[string]int64, error) {
+	userAgents, err := d.Devices.SelectUserAgentsSince(ctx, nil, int64(gomatrixserverlib.AsTimestamp(since)))
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]int64)
+	for _, userAgent := range userAgents {
+		if version, ok := api.ClientVersion(userAgent, d.ClientVersionPatterns); ok {
+			result[version]++
+		}
+	}
+	return result, nil
+}
+
 // CreateLoginToken generates a token, stores and returns it. The lifetime is
 // determined by the loginTokenLifetime given to the Database constructor.
 func (d *Database) CreateLoginToken(ctx context.Context, data *api.LoginTokenData) (*api.LoginTokenMetadata, error) {
@@ -711,6 +1053,13 @@ func (d *Database) GetRoomNotificationCounts(ctx context.Context, localpart, roo
 	return d.Notifications.SelectRoomCounts(ctx, nil, localpart, roomID)
 }
 
+// NotificationReadRatio returns the number of stored notifications which have
+// been read and which are still unread, across all users. Notifications are
+// cleaned up after a while, so this only covers the recent ones.
+func (d *Database) NotificationReadRatio(ctx context.Context) (read, unread int64, err error) {
+	return d.Notifications.SelectReadCounts(ctx, nil)
+}
+
 func (d *Database) DeleteOldNotifications(ctx context.Context) error {
 	return d.Notifications.Clean(ctx, nil)
 }
@@ -17,11 +17,13 @@ package sqlite3
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"time"
 
 	"github.com/matrix-org/gomatrixserverlib"
 
 	"github.com/matrix-org/dendrite/clientapi/userutil"
+	"github.com/matrix-org/dendrite/internal"
 	"github.com/matrix-org/dendrite/internal/sqlutil"
 	"github.com/matrix-org/dendrite/userapi/api"
 	"github.com/matrix-org/dendrite/userapi/storage/tables"
@@ -43,20 +45,24 @@ CREATE TABLE IF NOT EXISTS account_accounts (
     -- If the account is currently active
     is_deactivated BOOLEAN DEFAULT 0,
 	-- The account_type (user = 1, guest = 2, admin = 3, appservice = 4)
-	account_type INTEGER NOT NULL
+	account_type INTEGER NOT NULL,
+	-- When the password was last changed, as a unix timestamp (ms resolution), if ever.
+	password_changed_ts BIGINT,
+	-- The registration flow used to create the account, e.g. "m.login.dummy", if known.
+	registration_flow TEXT
     -- TODO:
     -- upgraded_ts, devices, any email reset stuff?
 );
 `
 
 const insertAccountSQL = "" +
-	"INSERT INTO account_accounts(localpart, created_ts, password_hash, appservice_id, account_type) VALUES ($1, $2, $3, $4, $5)"
+	"INSERT INTO account_accounts(localpart, created_ts, password_hash, appservice_id, account_type, registration_flow) VALUES ($1, $2, $3, $4, $5, $6)"
 
 const updatePasswordSQL = "" +
-	"UPDATE account_accounts SET password_hash = $1 WHERE localpart = $2"
+	"UPDATE account_accounts SET password_hash = $1, password_changed_ts = $2 WHERE localpart = $3"
 
 const deactivateAccountSQL = "" +
-	"UPDATE account_accounts SET is_deactivated = 1 WHERE localpart = $1"
+	"UPDATE account_accounts SET is_deactivated = 1 WHERE localpart = $1 AND is_deactivated = 0"
 
 const selectAccountByLocalpartSQL = "" +
 	"SELECT localpart, appservice_id, account_type FROM account_accounts WHERE localpart = $1"
@@ -67,15 +73,48 @@ const selectPasswordHashSQL = "" +
 const selectNewNumericLocalpartSQL = "" +
 	"SELECT COUNT(localpart) FROM account_accounts"
 
+var selectActiveAccountCountSQL = "" +
+	"SELECT COUNT(*) FROM account_accounts WHERE is_deactivated = 0" +
+	" AND account_type IN (" + fmt.Sprintf("%d, %d", api.AccountTypeUser, api.AccountTypeAdmin) + ")"
+
+// The prefix is long enough to hold the algorithm and cost of a bcrypt hash, e.g. "$2a$10$".
+const selectPasswordHashPrefixCountsSQL = "" +
+	"SELECT SUBSTR(COALESCE(password_hash, ''), 1, 7), COUNT(*) FROM account_accounts" +
+	" WHERE is_deactivated = 0 GROUP BY 1"
+
+var selectRegistrationFlowCountsSQL = "" +
+	"SELECT COALESCE(registration_flow, ''), COUNT(*) FROM account_accounts" +
+	" WHERE created_ts >= $1 AND created_ts < $2 AND account_type != " + fmt.Sprintf("%d", api.AccountTypeGuest) +
+	" GROUP BY COALESCE(registration_flow, '')"
+
+var selectRegistrationCountsByHourSQL = "" +
+	"SELECT created_ts / 3600000 AS hour, COUNT(*) FROM account_accounts" +
+	" WHERE created_ts >= $1 AND account_type != " + fmt.Sprintf("%d", api.AccountTypeGuest) +
+	" GROUP BY hour"
+
+const selectAccountTypeCountsSQL = "" +
+	"SELECT account_type, COUNT(*) FROM account_accounts WHERE is_deactivated = 0 GROUP BY account_type"
+
+const selectAppserviceUserCountsSQL = "" +
+	"SELECT appservice_id, COUNT(*) FROM account_accounts" +
+	" WHERE appservice_id IS NOT NULL AND appservice_id != '' AND is_deactivated = 0" +
+	" GROUP BY appservice_id"
+
 type accountsStatements struct {
-	db                            *sql.DB
-	insertAccountStmt             *sql.Stmt
-	updatePasswordStmt            *sql.Stmt
-	deactivateAccountStmt         *sql.Stmt
-	selectAccountByLocalpartStmt  *sql.Stmt
-	selectPasswordHashStmt        *sql.Stmt
-	selectNewNumericLocalpartStmt *sql.Stmt
-	serverName                    gomatrixserverlib.ServerName
+	db                                 *sql.DB
+	insertAccountStmt                  *sql.Stmt
+	updatePasswordStmt                 *sql.Stmt
+	deactivateAccountStmt              *sql.Stmt
+	selectAccountByLocalpartStmt       *sql.Stmt
+	selectPasswordHashStmt             *sql.Stmt
+	selectNewNumericLocalpartStmt      *sql.Stmt
+	selectActiveAccountCountStmt       *sql.Stmt
+	selectPasswordHashPrefixCountsStmt *sql.Stmt
+	selectRegistrationFlowCountsStmt   *sql.Stmt
+	selectRegistrationCountsByHourStmt *sql.Stmt
+	selectAppserviceUserCountsStmt     *sql.Stmt
+	selectAccountTypeCountsStmt        *sql.Stmt
+	serverName                         gomatrixserverlib.ServerName
 }
 
 func NewSQLiteAccountsTable(db *sql.DB, serverName gomatrixserverlib.ServerName) (tables.AccountsTable, error) {
@@ -94,6 +133,12 @@ func NewSQLiteAccountsTable(db *sql.DB, serverName gomatrixserverlib.ServerName)
 		{&s.selectAccountByLocalpartStmt, selectAccountByLocalpartSQL},
 		{&s.selectPasswordHashStmt, selectPasswordHashSQL},
 		{&s.selectNewNumericLocalpartStmt, selectNewNumericLocalpartSQL},
+		{&s.selectActiveAccountCountStmt, selectActiveAccountCountSQL},
+		{&s.selectPasswordHashPrefixCountsStmt, selectPasswordHashPrefixCountsSQL},
+		{&s.selectRegistrationFlowCountsStmt, selectRegistrationFlowCountsSQL},
+		{&s.selectRegistrationCountsByHourStmt, selectRegistrationCountsByHourSQL},
+		{&s.selectAppserviceUserCountsStmt, selectAppserviceUserCountsSQL},
+		{&s.selectAccountTypeCountsStmt, selectAccountTypeCountsSQL},
 	}.Prepare(db)
 }
 
@@ -102,15 +147,19 @@ func NewSQLiteAccountsTable(db *sql.DB, serverName gomatrixserverlib.ServerName)
 // on success.
 func (s *accountsStatements) InsertAccount(
 	ctx context.Context, txn *sql.Tx, localpart, hash, appserviceID string, accountType api.AccountType,
+	registrationFlow string,
 ) (*api.Account, error) {
 	createdTimeMS := time.Now().UnixNano() / 1000000
 	stmt := s.insertAccountStmt
 
+	// accounts which weren't created through a registration flow are left as NULL
+	flow := sql.NullString{String: registrationFlow, Valid: registrationFlow != ""}
+
 	var err error
 	if accountType != api.AccountTypeAppService {
-		_, err = sqlutil.TxStmt(txn, stmt).ExecContext(ctx, localpart, createdTimeMS, hash, nil, accountType)
+		_, err = sqlutil.TxStmt(txn, stmt).ExecContext(ctx, localpart, createdTimeMS, hash, nil, accountType, flow)
 	} else {
-		_, err = sqlutil.TxStmt(txn, stmt).ExecContext(ctx, localpart, createdTimeMS, hash, appserviceID, accountType)
+		_, err = sqlutil.TxStmt(txn, stmt).ExecContext(ctx, localpart, createdTimeMS, hash, appserviceID, accountType, flow)
 	}
 	if err != nil {
 		return nil, err
@@ -127,15 +176,23 @@ func (s *accountsStatements) InsertAccount(
 func (s *accountsStatements) UpdatePassword(
 	ctx context.Context, localpart, passwordHash string,
 ) (err error) {
-	_, err = s.updatePasswordStmt.ExecContext(ctx, passwordHash, localpart)
+	changedTimeMS := time.Now().UnixNano() / 1000000
+	_, err = s.updatePasswordStmt.ExecContext(ctx, passwordHash, changedTimeMS, localpart)
 	return
 }
 
 func (s *accountsStatements) DeactivateAccount(
-	ctx context.Context, localpart string,
-) (err error) {
-	_, err = s.deactivateAccountStmt.ExecContext(ctx, localpart)
-	return
+	ctx context.Context, txn *sql.Tx, localpart string,
+) (bool, error) {
+	result, err := sqlutil.TxStmt(txn, s.deactivateAccountStmt).ExecContext(ctx, localpart)
+	if err != nil {
+		return false, err
+	}
+	ra, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return ra > 0, nil
 }
 
 func (s *accountsStatements) SelectPasswordHash(
@@ -179,3 +236,109 @@ func (s *accountsStatements) SelectNewNumericLocalpart(
 	err = stmt.QueryRowContext(ctx).Scan(&id)
 	return
 }
+
+func (s *accountsStatements) SelectActiveAccountCount(
+	ctx context.Context, txn *sql.Tx,
+) (count int64, err error) {
+	err = sqlutil.TxStmt(txn, s.selectActiveAccountCountStmt).QueryRowContext(ctx).Scan(&count)
+	return
+}
+
+func (s *accountsStatements) SelectPasswordHashPrefixCounts(
+	ctx context.Context, txn *sql.Tx,
+) (map[string]int64, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectPasswordHashPrefixCountsStmt).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectPasswordHashPrefixCounts: rows.close() failed")
+	result := make(map[string]int64)
+	for rows.Next() {
+		var prefix string
+		var count int64
+		if err = rows.Scan(&prefix, &count); err != nil {
+			return nil, err
+		}
+		result[prefix] += count
+	}
+	return result, rows.Err()
+}
+
+func (s *accountsStatements) SelectRegistrationFlowCounts(
+	ctx context.Context, txn *sql.Tx, fromMS, toMS int64,
+) (map[string]int64, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectRegistrationFlowCountsStmt).QueryContext(ctx, fromMS, toMS)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectRegistrationFlowCounts: rows.close() failed")
+	result := make(map[string]int64)
+	for rows.Next() {
+		var flow string
+		var count int64
+		if err = rows.Scan(&flow, &count); err != nil {
+			return nil, err
+		}
+		result[flow] = count
+	}
+	return result, rows.Err()
+}
+
+func (s *accountsStatements) SelectRegistrationCountsByHour(
+	ctx context.Context, txn *sql.Tx, fromMS int64,
+) (map[int64]int64, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectRegistrationCountsByHourStmt).QueryContext(ctx, fromMS)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectRegistrationCountsByHour: rows.close() failed")
+	result := make(map[int64]int64)
+	for rows.Next() {
+		var hour, count int64
+		if err = rows.Scan(&hour, &count); err != nil {
+			return nil, err
+		}
+		result[hour] = count
+	}
+	return result, rows.Err()
+}
+
+func (s *accountsStatements) SelectAppserviceUserCounts(
+	ctx context.Context, txn *sql.Tx,
+) (map[string]int64, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectAppserviceUserCountsStmt).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectAppserviceUserCounts: rows.close() failed")
+	result := make(map[string]int64)
+	for rows.Next() {
+		var appserviceID string
+		var count int64
+		if err = rows.Scan(&appserviceID, &count); err != nil {
+			return nil, err
+		}
+		result[appserviceID] = count
+	}
+	return result, rows.Err()
+}
+
+func (s *accountsStatements) SelectAccountTypeCounts(
+	ctx context.Context, txn *sql.Tx,
+) (map[api.AccountType]int64, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectAccountTypeCountsStmt).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectAccountTypeCounts: rows.close() failed")
+	result := make(map[api.AccountType]int64)
+	for rows.Next() {
+		var accountType api.AccountType
+		var count int64
+		if err = rows.Scan(&accountType, &count); err != nil {
+			return nil, err
+		}
+		result[accountType] = count
+	}
+	return result, rows.Err()
+}
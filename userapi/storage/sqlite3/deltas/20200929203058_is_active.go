@@ -12,6 +12,9 @@ import (
 func LoadFromGoose() {
 	goose.AddMigration(UpIsActive, DownIsActive)
 	goose.AddMigration(UpAddAccountType, DownAddAccountType)
+	goose.AddMigration(UpAddPasswordChangedTS, DownAddPasswordChangedTS)
+	goose.AddMigration(UpAddRegistrationFlow, DownAddRegistrationFlow)
+	goose.AddMigration(UpSeedStatsCounters, DownSeedStatsCounters)
 }
 
 func LoadIsActive(m *sqlutil.Migrations) {
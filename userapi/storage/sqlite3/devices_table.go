@@ -17,6 +17,7 @@ package sqlite3
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"strings"
 	"time"
 
@@ -83,19 +84,82 @@ const selectDevicesByIDSQL = "" +
 const updateDeviceLastSeen = "" +
 	"UPDATE device_devices SET last_seen_ts = $1, ip = $2 WHERE localpart = $3 AND device_id = $4"
 
+const selectUserAgentsSinceSQL = "" +
+	"SELECT user_agent FROM device_devices WHERE last_seen_ts >= $1 AND user_agent IS NOT NULL"
+
+// selectInactiveSincePasswordChangeCountSQL counts accounts which changed their
+// password but have no device which has been used since.
+const selectInactiveSincePasswordChangeCountSQL = "" +
+	"SELECT COUNT(*) FROM account_accounts WHERE is_deactivated = 0 AND password_changed_ts IS NOT NULL" +
+	" AND NOT EXISTS (SELECT 1 FROM device_devices WHERE device_devices.localpart = account_accounts.localpart" +
+	" AND device_devices.last_seen_ts > account_accounts.password_changed_ts)"
+
+// selectDormantAccountCountSQL counts user and admin accounts which have no
+// device that has been used since the given time, including accounts which
+// have no devices at all.
+var selectDormantAccountCountSQL = "" +
+	"SELECT COUNT(*) FROM account_accounts WHERE is_deactivated = 0" +
+	" AND account_type IN (" + fmt.Sprintf("%d, %d", api.AccountTypeUser, api.AccountTypeAdmin) + ")" +
+	" AND NOT EXISTS (SELECT 1 FROM device_devices WHERE device_devices.localpart = account_accounts.localpart" +
+	" AND device_devices.last_seen_ts >= $1)"
+
+// selectNamedDeviceCountsSQL counts devices with a display name other than
+// the device ID, along with the total number of devices.
+const selectNamedDeviceCountsSQL = "" +
+	"SELECT COALESCE(SUM(CASE WHEN display_name IS NOT NULL AND display_name != '' AND display_name != device_id THEN 1 ELSE 0 END), 0)," +
+	" COUNT(*) FROM device_devices"
+
+const selectDistinctIPCountSinceSQL = "" +
+	"SELECT COUNT(DISTINCT ip) FROM device_devices WHERE last_seen_ts >= $1 AND ip IS NOT NULL AND ip != ''"
+
+// selectDevicesCreatedSinceCountSQL counts devices of active user and admin
+// accounts created at or after the given time.
+var selectDevicesCreatedSinceCountSQL = "" +
+	"SELECT COUNT(*) FROM device_devices" +
+	" JOIN account_accounts ON device_devices.localpart = account_accounts.localpart" +
+	" WHERE device_devices.created_ts >= $1 AND account_accounts.is_deactivated = 0" +
+	" AND account_accounts.account_type IN (" + fmt.Sprintf("%d, %d", api.AccountTypeUser, api.AccountTypeAdmin) + ")"
+
+// selectUserAgentDiversitySQL returns the number of distinct user agents per
+// user summed over all users, and the number of users who have devices with a
+// user agent.
+const selectUserAgentDiversitySQL = "" +
+	"SELECT COUNT(*), COUNT(DISTINCT localpart) FROM (" +
+	"SELECT DISTINCT localpart, user_agent FROM device_devices WHERE user_agent IS NOT NULL AND user_agent != ''" +
+	") AS user_agents"
+
+// selectRetentionCohortCountsSQL counts the user and admin accounts created
+// in [$2, $3), and those of them with a device last seen at least $1
+// milliseconds after the account was created.
+var selectRetentionCohortCountsSQL = "" +
+	"SELECT COUNT(*), COALESCE(SUM(CASE WHEN EXISTS (" +
+	"SELECT 1 FROM device_devices WHERE device_devices.localpart = account_accounts.localpart" +
+	" AND device_devices.last_seen_ts >= account_accounts.created_ts + $1" +
+	") THEN 1 ELSE 0 END), 0) FROM account_accounts" +
+	" WHERE created_ts >= $2 AND created_ts < $3" +
+	" AND account_type IN (" + fmt.Sprintf("%d, %d", api.AccountTypeUser, api.AccountTypeAdmin) + ")"
+
 type devicesStatements struct {
-	db                           *sql.DB
-	insertDeviceStmt             *sql.Stmt
-	selectDevicesCountStmt       *sql.Stmt
-	selectDeviceByTokenStmt      *sql.Stmt
-	selectDeviceByIDStmt         *sql.Stmt
-	selectDevicesByIDStmt        *sql.Stmt
-	selectDevicesByLocalpartStmt *sql.Stmt
-	updateDeviceNameStmt         *sql.Stmt
-	updateDeviceLastSeenStmt     *sql.Stmt
-	deleteDeviceStmt             *sql.Stmt
-	deleteDevicesByLocalpartStmt *sql.Stmt
-	serverName                   gomatrixserverlib.ServerName
+	db                                         *sql.DB
+	insertDeviceStmt                           *sql.Stmt
+	selectDevicesCountStmt                     *sql.Stmt
+	selectDeviceByTokenStmt                    *sql.Stmt
+	selectDeviceByIDStmt                       *sql.Stmt
+	selectDevicesByIDStmt                      *sql.Stmt
+	selectDevicesByLocalpartStmt               *sql.Stmt
+	updateDeviceNameStmt                       *sql.Stmt
+	updateDeviceLastSeenStmt                   *sql.Stmt
+	deleteDeviceStmt                           *sql.Stmt
+	deleteDevicesByLocalpartStmt               *sql.Stmt
+	selectUserAgentsSinceStmt                  *sql.Stmt
+	selectInactiveSincePasswordChangeCountStmt *sql.Stmt
+	selectDormantAccountCountStmt              *sql.Stmt
+	selectNamedDeviceCountsStmt                *sql.Stmt
+	selectDistinctIPCountSinceStmt             *sql.Stmt
+	selectDevicesCreatedSinceCountStmt         *sql.Stmt
+	selectUserAgentDiversityStmt               *sql.Stmt
+	selectRetentionCohortCountsStmt            *sql.Stmt
+	serverName                                 gomatrixserverlib.ServerName
 }
 
 func NewSQLiteDevicesTable(db *sql.DB, serverName gomatrixserverlib.ServerName) (tables.DevicesTable, error) {
@@ -118,6 +182,14 @@ func NewSQLiteDevicesTable(db *sql.DB, serverName gomatrixserverlib.ServerName)
 		{&s.deleteDevicesByLocalpartStmt, deleteDevicesByLocalpartSQL},
 		{&s.selectDevicesByIDStmt, selectDevicesByIDSQL},
 		{&s.updateDeviceLastSeenStmt, updateDeviceLastSeen},
+		{&s.selectUserAgentsSinceStmt, selectUserAgentsSinceSQL},
+		{&s.selectInactiveSincePasswordChangeCountStmt, selectInactiveSincePasswordChangeCountSQL},
+		{&s.selectDormantAccountCountStmt, selectDormantAccountCountSQL},
+		{&s.selectNamedDeviceCountsStmt, selectNamedDeviceCountsSQL},
+		{&s.selectDistinctIPCountSinceStmt, selectDistinctIPCountSinceSQL},
+		{&s.selectDevicesCreatedSinceCountStmt, selectDevicesCreatedSinceCountSQL},
+		{&s.selectUserAgentDiversityStmt, selectUserAgentDiversitySQL},
+		{&s.selectRetentionCohortCountsStmt, selectRetentionCohortCountsSQL},
 	}.Prepare(db)
 }
 
@@ -301,3 +373,70 @@ func (s *devicesStatements) UpdateDeviceLastSeen(ctx context.Context, txn *sql.T
 	_, err := stmt.ExecContext(ctx, lastSeenTs, ipAddr, localpart, deviceID)
 	return err
 }
+
+func (s *devicesStatements) SelectUserAgentsSince(ctx context.Context, txn *sql.Tx, lastSeenAfterMS int64) ([]string, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectUserAgentsSinceStmt).QueryContext(ctx, lastSeenAfterMS)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectUserAgentsSince: rows.close() failed")
+	var userAgents []string
+	for rows.Next() {
+		var userAgent string
+		if err = rows.Scan(&userAgent); err != nil {
+			return nil, err
+		}
+		userAgents = append(userAgents, userAgent)
+	}
+	return userAgents, rows.Err()
+}
+
+func (s *devicesStatements) SelectInactiveSincePasswordChangeCount(
+	ctx context.Context, txn *sql.Tx,
+) (count int64, err error) {
+	err = sqlutil.TxStmt(txn, s.selectInactiveSincePasswordChangeCountStmt).QueryRowContext(ctx).Scan(&count)
+	return
+}
+
+func (s *devicesStatements) SelectDormantAccountCount(
+	ctx context.Context, txn *sql.Tx, lastSeenBeforeMS int64,
+) (count int64, err error) {
+	err = sqlutil.TxStmt(txn, s.selectDormantAccountCountStmt).QueryRowContext(ctx, lastSeenBeforeMS).Scan(&count)
+	return
+}
+
+func (s *devicesStatements) SelectNamedDeviceCounts(
+	ctx context.Context, txn *sql.Tx,
+) (named, total int64, err error) {
+	err = sqlutil.TxStmt(txn, s.selectNamedDeviceCountsStmt).QueryRowContext(ctx).Scan(&named, &total)
+	return
+}
+
+func (s *devicesStatements) SelectDistinctIPCountSince(
+	ctx context.Context, txn *sql.Tx, lastSeenAfterMS int64,
+) (count int64, err error) {
+	err = sqlutil.TxStmt(txn, s.selectDistinctIPCountSinceStmt).QueryRowContext(ctx, lastSeenAfterMS).Scan(&count)
+	return
+}
+
+func (s *devicesStatements) SelectDevicesCreatedSinceCount(
+	ctx context.Context, txn *sql.Tx, createdAfterMS int64,
+) (count int64, err error) {
+	err = sqlutil.TxStmt(txn, s.selectDevicesCreatedSinceCountStmt).QueryRowContext(ctx, createdAfterMS).Scan(&count)
+	return
+}
+
+func (s *devicesStatements) SelectUserAgentDiversity(
+	ctx context.Context, txn *sql.Tx,
+) (userAgents, users int64, err error) {
+	err = sqlutil.TxStmt(txn, s.selectUserAgentDiversityStmt).QueryRowContext(ctx).Scan(&userAgents, &users)
+	return
+}
+
+func (s *devicesStatements) SelectRetentionCohortCounts(
+	ctx context.Context, txn *sql.Tx, createdFromMS, createdToMS, retainedAfterMS int64,
+) (registered, retained int64, err error) {
+	stmt := sqlutil.TxStmt(txn, s.selectRetentionCohortCountsStmt)
+	err = stmt.QueryRowContext(ctx, retainedAfterMS, createdFromMS, createdToMS).Scan(&registered, &retained)
+	return
+}
@@ -22,6 +22,7 @@ import (
 	"strconv"
 
 	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/userapi/api"
 	"github.com/matrix-org/dendrite/userapi/storage/tables"
 )
 
@@ -59,13 +60,22 @@ const selectKeyBackupSQL = "" +
 const selectLatestVersionSQL = "" +
 	"SELECT MAX(version) FROM account_e2e_room_keys_versions WHERE user_id = $1"
 
+// selectUsersWithKeyBackupCountSQL counts the active user and admin accounts
+// with at least one backup version which isn't deleted.
+var selectUsersWithKeyBackupCountSQL = "" +
+	"SELECT COUNT(DISTINCT user_id) FROM account_e2e_room_keys_versions" +
+	" JOIN account_accounts ON SUBSTR(user_id, 2, INSTR(user_id, ':') - 2) = account_accounts.localpart" +
+	" WHERE deleted = 0 AND account_accounts.is_deactivated = 0" +
+	" AND account_accounts.account_type IN (" + fmt.Sprintf("%d, %d", api.AccountTypeUser, api.AccountTypeAdmin) + ")"
+
 type keyBackupVersionStatements struct {
-	insertKeyBackupStmt         *sql.Stmt
-	updateKeyBackupAuthDataStmt *sql.Stmt
-	deleteKeyBackupStmt         *sql.Stmt
-	selectKeyBackupStmt         *sql.Stmt
-	selectLatestVersionStmt     *sql.Stmt
-	updateKeyBackupETagStmt     *sql.Stmt
+	insertKeyBackupStmt               *sql.Stmt
+	updateKeyBackupAuthDataStmt       *sql.Stmt
+	deleteKeyBackupStmt               *sql.Stmt
+	selectKeyBackupStmt               *sql.Stmt
+	selectLatestVersionStmt           *sql.Stmt
+	updateKeyBackupETagStmt           *sql.Stmt
+	selectUsersWithKeyBackupCountStmt *sql.Stmt
 }
 
 func NewSQLiteKeyBackupVersionTable(db *sql.DB) (tables.KeyBackupVersionTable, error) {
@@ -81,6 +91,7 @@ func NewSQLiteKeyBackupVersionTable(db *sql.DB) (tables.KeyBackupVersionTable, e
 		{&s.selectKeyBackupStmt, selectKeyBackupSQL},
 		{&s.selectLatestVersionStmt, selectLatestVersionSQL},
 		{&s.updateKeyBackupETagStmt, updateKeyBackupETagSQL},
+		{&s.selectUsersWithKeyBackupCountStmt, selectUsersWithKeyBackupCountSQL},
 	}.Prepare(db)
 }
 
@@ -159,3 +170,10 @@ func (s *keyBackupVersionStatements) SelectKeyBackup(
 	authData = json.RawMessage(authDataStr)
 	return
 }
+
+func (s *keyBackupVersionStatements) SelectUsersWithKeyBackupCount(
+	ctx context.Context, txn *sql.Tx,
+) (count int64, err error) {
+	err = sqlutil.TxStmt(txn, s.selectUsersWithKeyBackupCountStmt).QueryRowContext(ctx).Scan(&count)
+	return
+}
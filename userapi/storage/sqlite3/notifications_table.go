@@ -36,6 +36,7 @@ type notificationsStatements struct {
 	selectCountStmt        *sql.Stmt
 	selectRoomCountsStmt   *sql.Stmt
 	cleanNotificationsStmt *sql.Stmt
+	selectReadCountsStmt   *sql.Stmt
 }
 
 const notificationSchema = `
@@ -79,6 +80,9 @@ const selectRoomNotificationCountsSQL = "" +
 	"SELECT COUNT(*), COUNT(*) FILTER (WHERE highlight) FROM userapi_notifications " +
 	"WHERE localpart = $1 AND room_id = $2 AND NOT read"
 
+const selectNotificationReadCountsSQL = "" +
+	"SELECT COUNT(*) FILTER (WHERE read), COUNT(*) FILTER (WHERE NOT read) FROM userapi_notifications"
+
 const cleanNotificationsSQL = "" +
 	"DELETE FROM userapi_notifications WHERE" +
 	" (highlight = FALSE AND ts_ms < $1) OR (highlight = TRUE AND ts_ms < $2)"
@@ -97,6 +101,7 @@ func NewSQLiteNotificationTable(db *sql.DB) (tables.NotificationTable, error) {
 		{&s.selectCountStmt, selectNotificationCountSQL},
 		{&s.selectRoomCountsStmt, selectRoomNotificationCountsSQL},
 		{&s.cleanNotificationsStmt, cleanNotificationsSQL},
+		{&s.selectReadCountsStmt, selectNotificationReadCountsSQL},
 	}.Prepare(db)
 }
 
@@ -233,3 +238,8 @@ func (s *notificationsStatements) SelectRoomCounts(ctx context.Context, txn *sql
 	}
 	return 0, 0, rows.Err()
 }
+
+func (s *notificationsStatements) SelectReadCounts(ctx context.Context, txn *sql.Tx) (read, unread int64, err error) {
+	err = sqlutil.TxStmt(txn, s.selectReadCountsStmt).QueryRowContext(ctx).Scan(&read, &unread)
+	return
+}
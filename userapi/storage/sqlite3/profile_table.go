@@ -52,6 +52,12 @@ const setDisplayNameSQL = "" +
 const selectProfilesBySearchSQL = "" +
 	"SELECT localpart, display_name, avatar_url FROM account_profiles WHERE localpart LIKE $1 OR display_name LIKE $1 LIMIT $2"
 
+// Profiles which still have no display name, or only the default display name
+// set at registration (the localpart), are counted as stale.
+const selectStaleProfileCountSQL = "" +
+	"SELECT COUNT(*) FROM account_profiles" +
+	" WHERE (display_name IS NULL OR display_name = '' OR display_name = localpart) AND localpart != $1"
+
 type profilesStatements struct {
 	db                           *sql.DB
 	serverNoticesLocalpart       string
@@ -60,6 +66,7 @@ type profilesStatements struct {
 	setAvatarURLStmt             *sql.Stmt
 	setDisplayNameStmt           *sql.Stmt
 	selectProfilesBySearchStmt   *sql.Stmt
+	selectStaleProfileCountStmt  *sql.Stmt
 }
 
 func NewSQLiteProfilesTable(db *sql.DB, serverNoticesLocalpart string) (tables.ProfileTable, error) {
@@ -77,6 +84,7 @@ func NewSQLiteProfilesTable(db *sql.DB, serverNoticesLocalpart string) (tables.P
 		{&s.setAvatarURLStmt, setAvatarURLSQL},
 		{&s.setDisplayNameStmt, setDisplayNameSQL},
 		{&s.selectProfilesBySearchStmt, selectProfilesBySearchSQL},
+		{&s.selectStaleProfileCountStmt, selectStaleProfileCountSQL},
 	}.Prepare(db)
 }
 
@@ -139,3 +147,10 @@ func (s *profilesStatements) SelectProfilesBySearch(
 	}
 	return profiles, nil
 }
+
+func (s *profilesStatements) SelectStaleProfileCount(
+	ctx context.Context, txn *sql.Tx,
+) (count int64, err error) {
+	err = sqlutil.TxStmt(txn, s.selectStaleProfileCountStmt).QueryRowContext(ctx, s.serverNoticesLocalpart).Scan(&count)
+	return
+}
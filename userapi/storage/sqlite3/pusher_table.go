@@ -18,6 +18,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 
 	"github.com/matrix-org/dendrite/internal"
 	"github.com/matrix-org/dendrite/internal/sqlutil"
@@ -69,6 +70,14 @@ const deletePusherSQL = "" +
 const deletePushersByAppIdAndPushKeySQL = "" +
 	"DELETE FROM userapi_pushers WHERE app_id = $1 AND pushkey = $2"
 
+// selectUsersWithPushersCountSQL counts the active user and admin accounts
+// with at least one pusher.
+var selectUsersWithPushersCountSQL = "" +
+	"SELECT COUNT(DISTINCT userapi_pushers.localpart) FROM userapi_pushers" +
+	" JOIN account_accounts ON userapi_pushers.localpart = account_accounts.localpart" +
+	" WHERE account_accounts.is_deactivated = 0" +
+	" AND account_accounts.account_type IN (" + fmt.Sprintf("%d, %d", api.AccountTypeUser, api.AccountTypeAdmin) + ")"
+
 func NewSQLitePusherTable(db *sql.DB) (tables.PusherTable, error) {
 	s := &pushersStatements{}
 	_, err := db.Exec(pushersSchema)
@@ -80,6 +89,7 @@ func NewSQLitePusherTable(db *sql.DB) (tables.PusherTable, error) {
 		{&s.selectPushersStmt, selectPushersSQL},
 		{&s.deletePusherStmt, deletePusherSQL},
 		{&s.deletePushersByAppIdAndPushKeyStmt, deletePushersByAppIdAndPushKeySQL},
+		{&s.selectUsersWithPushersCountStmt, selectUsersWithPushersCountSQL},
 	}.Prepare(db)
 }
 
@@ -88,6 +98,7 @@ type pushersStatements struct {
 	selectPushersStmt                  *sql.Stmt
 	deletePusherStmt                   *sql.Stmt
 	deletePushersByAppIdAndPushKeyStmt *sql.Stmt
+	selectUsersWithPushersCountStmt    *sql.Stmt
 }
 
 // insertPusher creates a new pusher.
@@ -155,3 +166,10 @@ func (s *pushersStatements) DeletePushers(
 	_, err := s.deletePushersByAppIdAndPushKeyStmt.ExecContext(ctx, appid, pushkey)
 	return err
 }
+
+func (s *pushersStatements) SelectUsersWithPushersCount(
+	ctx context.Context, txn *sql.Tx,
+) (count int64, err error) {
+	err = sqlutil.TxStmt(txn, s.selectUsersWithPushersCountStmt).QueryRowContext(ctx).Scan(&count)
+	return
+}
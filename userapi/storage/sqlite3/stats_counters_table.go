@@ -0,0 +1,86 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/matrix-org/dendrite/internal"
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/userapi/storage/tables"
+)
+
+const statsCountersSchema = `
+-- Stores running counters which are updated as accounts change, so that
+-- cheap statistics don't need to scan the accounts table.
+CREATE TABLE IF NOT EXISTS userapi_stats_counters (
+	-- The name of the counter, e.g. "registrations"
+	name TEXT NOT NULL PRIMARY KEY,
+	-- The current value of the counter
+	value BIGINT NOT NULL
+);
+`
+
+const incrementStatsCounterSQL = "" +
+	"INSERT INTO userapi_stats_counters (name, value) VALUES ($1, $2)" +
+	" ON CONFLICT (name) DO UPDATE SET value = userapi_stats_counters.value + excluded.value"
+
+const selectStatsCountersSQL = "" +
+	"SELECT name, value FROM userapi_stats_counters"
+
+type statsCountersStatements struct {
+	incrementStatsCounterStmt *sql.Stmt
+	selectStatsCountersStmt   *sql.Stmt
+}
+
+func NewSQLiteStatsCountersTable(db *sql.DB) (tables.StatsCountersTable, error) {
+	s := &statsCountersStatements{}
+	_, err := db.Exec(statsCountersSchema)
+	if err != nil {
+		return nil, err
+	}
+	return s, sqlutil.StatementList{
+		{&s.incrementStatsCounterStmt, incrementStatsCounterSQL},
+		{&s.selectStatsCountersStmt, selectStatsCountersSQL},
+	}.Prepare(db)
+}
+
+func (s *statsCountersStatements) IncrementCounter(
+	ctx context.Context, txn *sql.Tx, name string, delta int64,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.incrementStatsCounterStmt).ExecContext(ctx, name, delta)
+	return err
+}
+
+func (s *statsCountersStatements) SelectCounters(
+	ctx context.Context, txn *sql.Tx,
+) (map[string]int64, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectStatsCountersStmt).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectCounters: rows.close() failed")
+	counters := make(map[string]int64)
+	for rows.Next() {
+		var name string
+		var value int64
+		if err = rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		counters[name] = value
+	}
+	return counters, rows.Err()
+}
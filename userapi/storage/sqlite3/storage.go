@@ -22,6 +22,7 @@ import (
 
 	"github.com/matrix-org/dendrite/internal/sqlutil"
 	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/userapi/api"
 
 	"github.com/matrix-org/dendrite/userapi/storage/shared"
 	"github.com/matrix-org/dendrite/userapi/storage/sqlite3/deltas"
@@ -31,7 +32,7 @@ import (
 )
 
 // NewDatabase creates a new accounts and profiles database
-func NewDatabase(dbProperties *config.DatabaseOptions, serverName gomatrixserverlib.ServerName, bcryptCost int, openIDTokenLifetimeMS int64, loginTokenLifetime time.Duration, serverNoticesLocalpart string) (*shared.Database, error) {
+func NewDatabase(dbProperties *config.DatabaseOptions, serverName gomatrixserverlib.ServerName, bcryptCost int, openIDTokenLifetimeMS int64, loginTokenLifetime time.Duration, serverNoticesLocalpart string, userAgentRules []config.UserAgentRule, clientVersionPatterns []config.ClientVersionPattern) (*shared.Database, error) {
 	db, err := sqlutil.Open(dbProperties)
 	if err != nil {
 		return nil, err
@@ -46,6 +47,9 @@ func NewDatabase(dbProperties *config.DatabaseOptions, serverName gomatrixserver
 	deltas.LoadIsActive(m)
 	//deltas.LoadLastSeenTSIP(m)
 	deltas.LoadAddAccountType(m)
+	deltas.LoadAddPasswordChangedTS(m)
+	deltas.LoadAddRegistrationFlow(m)
+	deltas.LoadSeedStatsCounters(m)
 	if err = m.RunDeltas(db, dbProperties); err != nil {
 		return nil, err
 	}
@@ -94,6 +98,14 @@ func NewDatabase(dbProperties *config.DatabaseOptions, serverName gomatrixserver
 	if err != nil {
 		return nil, fmt.Errorf("NewPostgresNotificationTable: %w", err)
 	}
+	statsCountersTable, err := NewSQLiteStatsCountersTable(db)
+	if err != nil {
+		return nil, fmt.Errorf("NewSQLiteStatsCountersTable: %w", err)
+	}
+	versionPatterns, err := api.CompileClientVersionPatterns(clientVersionPatterns)
+	if err != nil {
+		return nil, err
+	}
 	return &shared.Database{
 		AccountDatas:          accountDataTable,
 		Accounts:              accountsTable,
@@ -106,11 +118,14 @@ func NewDatabase(dbProperties *config.DatabaseOptions, serverName gomatrixserver
 		ThreePIDs:             threePIDTable,
 		Pushers:               pusherTable,
 		Notifications:         notificationsTable,
+		Counters:              statsCountersTable,
 		ServerName:            serverName,
 		DB:                    db,
 		Writer:                sqlutil.NewExclusiveWriter(),
 		LoginTokenLifetime:    loginTokenLifetime,
 		BcryptCost:            bcryptCost,
 		OpenIDTokenLifetimeMS: openIDTokenLifetimeMS,
+		UserAgentRules:        userAgentRules,
+		ClientVersionPatterns: versionPatterns,
 	}, nil
 }
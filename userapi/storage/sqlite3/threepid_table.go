@@ -17,9 +17,11 @@ package sqlite3
 import (
 	"context"
 	"database/sql"
+	"fmt"
 
 	"github.com/matrix-org/dendrite/internal"
 	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/userapi/api"
 	"github.com/matrix-org/dendrite/userapi/storage/tables"
 
 	"github.com/matrix-org/dendrite/clientapi/auth/authtypes"
@@ -53,12 +55,29 @@ const insertThreePIDSQL = "" +
 const deleteThreePIDSQL = "" +
 	"DELETE FROM account_threepid WHERE threepid = $1 AND medium = $2"
 
+var selectActiveAccountThreePIDCountSQL = "" +
+	"SELECT COUNT(*) FROM account_threepid" +
+	" JOIN account_accounts ON account_threepid.localpart = account_accounts.localpart" +
+	" WHERE account_accounts.is_deactivated = 0" +
+	" AND account_accounts.account_type IN (" + fmt.Sprintf("%d, %d", api.AccountTypeUser, api.AccountTypeAdmin) + ")"
+
+// selectThreePIDMediumCountsSQL counts the active user and admin accounts with
+// at least one 3PID of each medium.
+var selectThreePIDMediumCountsSQL = "" +
+	"SELECT medium, COUNT(DISTINCT account_threepid.localpart) FROM account_threepid" +
+	" JOIN account_accounts ON account_threepid.localpart = account_accounts.localpart" +
+	" WHERE account_accounts.is_deactivated = 0" +
+	" AND account_accounts.account_type IN (" + fmt.Sprintf("%d, %d", api.AccountTypeUser, api.AccountTypeAdmin) + ")" +
+	" GROUP BY medium"
+
 type threepidStatements struct {
-	db                              *sql.DB
-	selectLocalpartForThreePIDStmt  *sql.Stmt
-	selectThreePIDsForLocalpartStmt *sql.Stmt
-	insertThreePIDStmt              *sql.Stmt
-	deleteThreePIDStmt              *sql.Stmt
+	db                                   *sql.DB
+	selectLocalpartForThreePIDStmt       *sql.Stmt
+	selectThreePIDsForLocalpartStmt      *sql.Stmt
+	insertThreePIDStmt                   *sql.Stmt
+	deleteThreePIDStmt                   *sql.Stmt
+	selectActiveAccountThreePIDCountStmt *sql.Stmt
+	selectThreePIDMediumCountsStmt       *sql.Stmt
 }
 
 func NewSQLiteThreePIDTable(db *sql.DB) (tables.ThreePIDTable, error) {
@@ -74,6 +93,8 @@ func NewSQLiteThreePIDTable(db *sql.DB) (tables.ThreePIDTable, error) {
 		{&s.selectThreePIDsForLocalpartStmt, selectThreePIDsForLocalpartSQL},
 		{&s.insertThreePIDStmt, insertThreePIDSQL},
 		{&s.deleteThreePIDStmt, deleteThreePIDSQL},
+		{&s.selectActiveAccountThreePIDCountStmt, selectActiveAccountThreePIDCountSQL},
+		{&s.selectThreePIDMediumCountsStmt, selectThreePIDMediumCountsSQL},
 	}.Prepare(db)
 }
 
@@ -126,3 +147,30 @@ func (s *threepidStatements) DeleteThreePID(
 	_, err = stmt.ExecContext(ctx, threepid, medium)
 	return err
 }
+
+func (s *threepidStatements) SelectActiveAccountThreePIDCount(
+	ctx context.Context, txn *sql.Tx,
+) (count int64, err error) {
+	err = sqlutil.TxStmt(txn, s.selectActiveAccountThreePIDCountStmt).QueryRowContext(ctx).Scan(&count)
+	return
+}
+
+func (s *threepidStatements) SelectThreePIDMediumCounts(
+	ctx context.Context, txn *sql.Tx,
+) (map[string]int64, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectThreePIDMediumCountsStmt).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectThreePIDMediumCounts: rows.close() failed")
+	result := make(map[string]int64)
+	for rows.Next() {
+		var medium string
+		var count int64
+		if err = rows.Scan(&medium, &count); err != nil {
+			return nil, err
+		}
+		result[medium] = count
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,1017 @@
+package storage_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/test"
+	"github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/dendrite/userapi/storage"
+	"github.com/matrix-org/dendrite/userapi/storage/tables"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/util"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const serverNoticesLocalpart = "notices"
+
+func mustCreateDatabase(t *testing.T, dbType test.DBType) (storage.Database, func()) {
+	connStr, close := test.PrepareDBConnectionString(t, dbType)
+	return mustOpenDatabase(t, connStr, nil, nil), close
+}
+
+func mustOpenDatabase(t *testing.T, connStr string, userAgentRules []config.UserAgentRule, clientVersionPatterns []config.ClientVersionPattern) storage.Database {
+	t.Helper()
+	db, err := storage.NewDatabase(&config.DatabaseOptions{
+		ConnectionString: config.DataSource(connStr),
+	}, "localhost", bcrypt.MinCost, config.DefaultOpenIDTokenLifetimeMS, api.DefaultLoginTokenLifetime*time.Millisecond, serverNoticesLocalpart, userAgentRules, clientVersionPatterns)
+	if err != nil {
+		t.Fatalf("NewDatabase returned %s", err)
+	}
+	return db
+}
+
+// mustBackdateDevice moves the created_ts or last_seen_ts column of the device
+// with the access token into the past, as devices are always stored as created
+// and last seen now.
+func mustBackdateDevice(t *testing.T, connStr, column, accessToken string, ago time.Duration) {
+	t.Helper()
+	db, err := sqlutil.Open(&config.DatabaseOptions{ConnectionString: config.DataSource(connStr)})
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err)
+	}
+	defer db.Close() // nolint: errcheck
+	if _, err = db.Exec(
+		"UPDATE device_devices SET "+column+" = $1 WHERE access_token = $2",
+		gomatrixserverlib.AsTimestamp(time.Now().Add(-ago)), accessToken,
+	); err != nil {
+		t.Fatalf("failed to backdate device: %s", err)
+	}
+}
+
+func mustCreateAccount(t *testing.T, db storage.Database, localpart string, accountType api.AccountType) {
+	t.Helper()
+	if _, err := db.CreateAccount(context.Background(), localpart, "", "", accountType, ""); err != nil {
+		t.Fatalf("failed to create account %q: %s", localpart, err)
+	}
+}
+
+func TestStaleProfileCount(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+		ctx := context.Background()
+
+		for _, localpart := range []string{"alice", "bob", "charlie", "dave", serverNoticesLocalpart} {
+			mustCreateAccount(t, db, localpart, api.AccountTypeUser)
+		}
+		// bob still has the default display name set at registration,
+		// charlie and dave have chosen their own, alice never had one.
+		for localpart, displayName := range map[string]string{
+			"bob":     "bob",
+			"charlie": "Charlie",
+			"dave":    "Dave the Brave",
+		} {
+			if err := db.SetDisplayName(ctx, localpart, displayName); err != nil {
+				t.Fatalf("failed to set display name: %s", err)
+			}
+		}
+
+		count, err := db.StaleProfileCount(ctx)
+		if err != nil {
+			t.Fatalf("StaleProfileCount returned %s", err)
+		}
+		if count != 2 {
+			t.Fatalf("expected 2 stale profiles, got %d", count)
+		}
+	})
+}
+
+func TestStatsCounters(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+		ctx := context.Background()
+
+		counters, err := db.StatsCounters(ctx)
+		if err != nil {
+			t.Fatalf("StatsCounters returned %s", err)
+		}
+		if counters[tables.StatsCounterRegistrations] != 0 || counters[tables.StatsCounterDeactivations] != 0 {
+			t.Fatalf("expected zero counters on a fresh database, got %v", counters)
+		}
+
+		for _, localpart := range []string{"alice", "bob", "charlie"} {
+			mustCreateAccount(t, db, localpart, api.AccountTypeUser)
+		}
+		// guests must not be counted as registrations
+		mustCreateAccount(t, db, "", api.AccountTypeGuest)
+		// creating an account which already exists must not be counted
+		if _, err = db.CreateAccount(ctx, "alice", "", "", api.AccountTypeUser, ""); err == nil {
+			t.Fatalf("expected creating a duplicate account to fail")
+		}
+		// deactivating an account again, or one which doesn't exist, must
+		// not be counted
+		for _, localpart := range []string{"bob", "bob", "nobody"} {
+			if err = db.DeactivateAccount(ctx, localpart); err != nil {
+				t.Fatalf("DeactivateAccount returned %s", err)
+			}
+		}
+
+		counters, err = db.StatsCounters(ctx)
+		if err != nil {
+			t.Fatalf("StatsCounters returned %s", err)
+		}
+		if got := counters[tables.StatsCounterRegistrations]; got != 3 {
+			t.Fatalf("expected 3 registrations, got %d", got)
+		}
+		if got := counters[tables.StatsCounterDeactivations]; got != 1 {
+			t.Fatalf("expected 1 deactivation, got %d", got)
+		}
+	})
+}
+
+func TestStatsCountersSeeded(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		connStr, close := test.PrepareDBConnectionString(t, dbType)
+		defer close()
+		db := mustOpenDatabase(t, connStr, nil, nil)
+		ctx := context.Background()
+
+		for _, localpart := range []string{"alice", "bob", "charlie"} {
+			mustCreateAccount(t, db, localpart, api.AccountTypeUser)
+		}
+		mustCreateAccount(t, db, "", api.AccountTypeGuest)
+		if err := db.DeactivateAccount(ctx, "bob"); err != nil {
+			t.Fatalf("DeactivateAccount returned %s", err)
+		}
+
+		// make the database look like one from before the counters existed,
+		// so that opening it again seeds them from the accounts
+		raw, err := sqlutil.Open(&config.DatabaseOptions{ConnectionString: config.DataSource(connStr)})
+		if err != nil {
+			t.Fatalf("failed to open database: %s", err)
+		}
+		defer raw.Close() // nolint: errcheck
+		for _, stmt := range []string{
+			"DELETE FROM userapi_stats_counters",
+			"DELETE FROM goose_db_version WHERE version_id = 2022060612000000",
+		} {
+			if _, err = raw.Exec(stmt); err != nil {
+				t.Fatalf("failed to execute %q: %s", stmt, err)
+			}
+		}
+
+		counters, err := mustOpenDatabase(t, connStr, nil, nil).StatsCounters(ctx)
+		if err != nil {
+			t.Fatalf("StatsCounters returned %s", err)
+		}
+		if got := counters[tables.StatsCounterRegistrations]; got != 3 {
+			t.Fatalf("expected 3 seeded registrations, got %d", got)
+		}
+		if got := counters[tables.StatsCounterDeactivations]; got != 1 {
+			t.Fatalf("expected 1 seeded deactivation, got %d", got)
+		}
+	})
+}
+
+func TestLastSeenPlatform(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		connStr, close := test.PrepareDBConnectionString(t, dbType)
+		defer close()
+		db := mustOpenDatabase(t, connStr, nil, nil)
+		ctx := context.Background()
+
+		mustCreateAccount(t, db, "alice", api.AccountTypeUser)
+		if _, _, err := db.LastSeenPlatform(ctx, "alice"); err != sql.ErrNoRows {
+			t.Fatalf("expected sql.ErrNoRows for a user without devices, got %v", err)
+		}
+
+		for _, dev := range []struct{ id, userAgent string }{
+			{"PHONE", "Element/1.4.4 (Linux; U; Android 11; Pixel 5 Build/RQ3A.210805.001.A1)"},
+			{"BROWSER", "Mozilla/5.0 (X11; Linux x86_64; rv:99.0) Gecko/20100101 Firefox/99.0"},
+		} {
+			deviceID := dev.id
+			if _, err := db.CreateDevice(ctx, "alice", &deviceID, "token_"+dev.id, nil, "127.0.0.1", dev.userAgent); err != nil {
+				t.Fatalf("CreateDevice returned %s", err)
+			}
+			// last seen timestamps have millisecond resolution
+			time.Sleep(5 * time.Millisecond)
+		}
+		assertPlatform := func(db storage.Database, want string) {
+			t.Helper()
+			platform, lastSeen, err := db.LastSeenPlatform(ctx, "alice")
+			if err != nil {
+				t.Fatalf("LastSeenPlatform returned %s", err)
+			}
+			if platform != want {
+				t.Fatalf("expected platform %q, got %q", want, platform)
+			}
+			if since := time.Since(lastSeen); since < 0 || since > time.Minute {
+				t.Fatalf("unexpected last seen time %s", lastSeen)
+			}
+		}
+		assertPlatform(db, api.PlatformWeb)
+
+		if err := db.UpdateDeviceLastSeen(ctx, "alice", "PHONE", "127.0.0.1"); err != nil {
+			t.Fatalf("UpdateDeviceLastSeen returned %s", err)
+		}
+		assertPlatform(db, api.PlatformAndroid)
+
+		// configured rules are tried before the built-in ones
+		assertPlatform(mustOpenDatabase(t, connStr, []config.UserAgentRule{
+			{Pattern: "PIXEL", Platform: "pixel"},
+		}, nil), "pixel")
+		assertPlatform(mustOpenDatabase(t, connStr, []config.UserAgentRule{
+			{Pattern: "iphone", Platform: "apple"},
+		}, nil), api.PlatformAndroid)
+		assertPlatform(mustOpenDatabase(t, connStr, []config.UserAgentRule{}, nil), api.PlatformAndroid)
+	})
+}
+
+func TestKeyBackupAdoption(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+		ctx := context.Background()
+
+		for _, localpart := range []string{"alice", "bob", "charlie", "dave"} {
+			mustCreateAccount(t, db, localpart, api.AccountTypeUser)
+		}
+		mustCreateAccount(t, db, "guest", api.AccountTypeGuest)
+		mustCreateAccount(t, db, "bot", api.AccountTypeAppService)
+		if err := db.DeactivateAccount(ctx, "dave"); err != nil {
+			t.Fatalf("DeactivateAccount returned %s", err)
+		}
+
+		authData := json.RawMessage(`{"public_key":"abcdef"}`)
+		// alice has two backup versions, bob deleted their only backup, and
+		// the backups of the deactivated, guest and appservice accounts
+		// aren't counted
+		var bobVersion string
+		for _, userID := range []string{
+			"@dave:localhost", "@guest:localhost", "@bot:localhost",
+			"@alice:localhost", "@alice:localhost", "@bob:localhost",
+		} {
+			version, err := db.CreateKeyBackup(ctx, userID, "m.megolm_backup.v1.curve25519-aes-sha2", authData)
+			if err != nil {
+				t.Fatalf("CreateKeyBackup returned %s", err)
+			}
+			bobVersion = version
+		}
+		if _, err := db.DeleteKeyBackup(ctx, "@bob:localhost", bobVersion); err != nil {
+			t.Fatalf("DeleteKeyBackup returned %s", err)
+		}
+
+		withBackup, total, err := db.KeyBackupAdoption(ctx)
+		if err != nil {
+			t.Fatalf("KeyBackupAdoption returned %s", err)
+		}
+		if withBackup != 1 || total != 3 {
+			t.Fatalf("expected 1 of 3 users with a key backup, got %d of %d", withBackup, total)
+		}
+	})
+}
+
+func TestActiveDevicesByClientVersion(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		connStr, close := test.PrepareDBConnectionString(t, dbType)
+		defer close()
+		db := mustOpenDatabase(t, connStr, nil, nil)
+		ctx := context.Background()
+
+		mustCreateAccount(t, db, "alice", api.AccountTypeUser)
+		createDevice := func(userAgent string) {
+			t.Helper()
+			if _, err := db.CreateDevice(ctx, "alice", nil, util.RandomString(16), nil, "127.0.0.1", userAgent); err != nil {
+				t.Fatalf("CreateDevice returned %s", err)
+			}
+		}
+		createDevice("Element/1.10.2 (iPhone; iOS 15.4; Scale/3.00)")
+		// last seen timestamps have millisecond resolution
+		time.Sleep(5 * time.Millisecond)
+		since := time.Now()
+		for _, userAgent := range []string{
+			"Element/1.11.1 (iPhone; iOS 15.4; Scale/3.00)",
+			"Element/1.11.4 (Linux; U; Android 12; Pixel 6 Build/SD1A.210817.036)",
+			"Element/1.10.9 (Linux; U; Android 11; Pixel 5 Build/RQ3A.210805.001.A1)",
+			"nheko/v0.9.2",
+			"Mozilla/5.0 (X11; Linux x86_64; rv:99.0) Gecko/20100101 Firefox/99.0",
+		} {
+			createDevice(userAgent)
+		}
+
+		got, err := db.ActiveDevicesByClientVersion(ctx, since)
+		if err != nil {
+			t.Fatalf("ActiveDevicesByClientVersion returned %s", err)
+		}
+		want := map[string]int64{"Element/1.11": 2, "Element/1.10": 1, "nheko/0.9": 1}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+
+		// configured patterns are tried before the built-in ones, in order
+		got, err = mustOpenDatabase(t, connStr, nil, []config.ClientVersionPattern{
+			{Client: "Firefox", Pattern: `Firefox/(\d+)`},
+			{Client: "Browser", Pattern: `Mozilla/(\d+)`},
+			{Client: "nheko-reborn", Pattern: `\bnheko/v?(\d+)`},
+		}).ActiveDevicesByClientVersion(ctx, since)
+		if err != nil {
+			t.Fatalf("ActiveDevicesByClientVersion returned %s", err)
+		}
+		want = map[string]int64{"Element/1.11": 2, "Element/1.10": 1, "nheko-reborn/0": 1, "Firefox/99": 1}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestAccountsByHashAlgorithm(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		connStr, close := test.PrepareDBConnectionString(t, dbType)
+		defer close()
+		ctx := context.Background()
+
+		// open the same database with different bcrypt costs to store different hashes
+		openWithCost := func(cost int) storage.Database {
+			db, err := storage.NewDatabase(&config.DatabaseOptions{
+				ConnectionString: config.DataSource(connStr),
+			}, "localhost", cost, config.DefaultOpenIDTokenLifetimeMS, api.DefaultLoginTokenLifetime*time.Millisecond, serverNoticesLocalpart, nil, nil)
+			if err != nil {
+				t.Fatalf("NewDatabase returned %s", err)
+			}
+			return db
+		}
+		createAccounts := func(db storage.Database, password string, localparts ...string) {
+			for _, localpart := range localparts {
+				if _, err := db.CreateAccount(ctx, localpart, password, "", api.AccountTypeUser, ""); err != nil {
+					t.Fatalf("failed to create account %q: %s", localpart, err)
+				}
+			}
+		}
+		createAccounts(openWithCost(bcrypt.MinCost+1), "password", "charlie")
+		db := openWithCost(bcrypt.MinCost)
+		createAccounts(db, "password", "alice", "bob", "eve")
+		createAccounts(db, "", "dave")
+		if err := db.DeactivateAccount(ctx, "eve"); err != nil {
+			t.Fatalf("DeactivateAccount returned %s", err)
+		}
+
+		got, err := db.AccountsByHashAlgorithm(ctx)
+		if err != nil {
+			t.Fatalf("AccountsByHashAlgorithm returned %s", err)
+		}
+		want := map[string]int64{
+			fmt.Sprintf("bcrypt/%d", bcrypt.MinCost):   2,
+			fmt.Sprintf("bcrypt/%d", bcrypt.MinCost+1): 1,
+			"none": 1,
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestUsersInactiveSincePasswordReset(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+		ctx := context.Background()
+
+		for _, localpart := range []string{"alice", "bob", "charlie", "dave"} {
+			mustCreateAccount(t, db, localpart, api.AccountTypeUser)
+		}
+		createDevice := func(localpart string) {
+			t.Helper()
+			if _, err := db.CreateDevice(ctx, localpart, nil, util.RandomString(16), nil, "127.0.0.1", ""); err != nil {
+				t.Fatalf("CreateDevice returned %s", err)
+			}
+			// timestamps have millisecond resolution
+			time.Sleep(5 * time.Millisecond)
+		}
+		setPassword := func(localpart string) {
+			t.Helper()
+			if err := db.SetPassword(ctx, localpart, "new password"); err != nil {
+				t.Fatalf("SetPassword returned %s", err)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		// alice resets their password and never logs in again
+		setPassword("alice")
+		// bob resets their password and logs in afterwards
+		setPassword("bob")
+		createDevice("bob")
+		// charlie's only device was last used before the reset
+		createDevice("charlie")
+		setPassword("charlie")
+		// dave never resets their password
+		createDevice("dave")
+
+		count, err := db.UsersInactiveSincePasswordReset(ctx)
+		if err != nil {
+			t.Fatalf("UsersInactiveSincePasswordReset returned %s", err)
+		}
+		if count != 2 {
+			t.Fatalf("expected 2 users inactive since a password reset, got %d", count)
+		}
+
+		// charlie uses their device again
+		devices, err := db.GetDevicesByLocalpart(ctx, "charlie")
+		if err != nil || len(devices) != 1 {
+			t.Fatalf("expected charlie to have one device, got %d (%v)", len(devices), err)
+		}
+		if err = db.UpdateDeviceLastSeen(ctx, "charlie", devices[0].ID, "127.0.0.1"); err != nil {
+			t.Fatalf("UpdateDeviceLastSeen returned %s", err)
+		}
+		if count, err = db.UsersInactiveSincePasswordReset(ctx); err != nil || count != 1 {
+			t.Fatalf("expected 1 user inactive since a password reset, got %d (%v)", count, err)
+		}
+	})
+}
+
+func TestUsersWithNoActiveDevices(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+		ctx := context.Background()
+
+		mustCreateAccount(t, db, "alice", api.AccountTypeUser)
+		mustCreateAccount(t, db, "bob", api.AccountTypeUser)
+		mustCreateAccount(t, db, "charlie", api.AccountTypeUser)
+		mustCreateAccount(t, db, "dave", api.AccountTypeUser)
+		mustCreateAccount(t, db, "guest", api.AccountTypeGuest)
+		createDevice := func(localpart string) {
+			t.Helper()
+			if _, err := db.CreateDevice(ctx, localpart, nil, util.RandomString(16), nil, "127.0.0.1", ""); err != nil {
+				t.Fatalf("CreateDevice returned %s", err)
+			}
+		}
+
+		// alice's device is dormant, bob has both a dormant and an active device,
+		// charlie has no devices and dave's account is deactivated
+		createDevice("alice")
+		createDevice("bob")
+		createDevice("dave")
+		time.Sleep(200 * time.Millisecond)
+		createDevice("bob")
+		if err := db.DeactivateAccount(ctx, "dave"); err != nil {
+			t.Fatalf("DeactivateAccount returned %s", err)
+		}
+
+		count, err := db.UsersWithNoActiveDevices(ctx, 100*time.Millisecond)
+		if err != nil {
+			t.Fatalf("UsersWithNoActiveDevices returned %s", err)
+		}
+		if count != 2 {
+			t.Fatalf("expected 2 users with no active devices, got %d", count)
+		}
+		if count, err = db.UsersWithNoActiveDevices(ctx, time.Hour); err != nil || count != 1 {
+			t.Fatalf("expected 1 user with no active devices, got %d (%v)", count, err)
+		}
+	})
+}
+
+func TestDevicesWithCustomNames(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+		ctx := context.Background()
+
+		mustCreateAccount(t, db, "alice", api.AccountTypeUser)
+		deviceID := "ALICEDEVICE"
+		for _, tc := range []struct {
+			deviceID    *string
+			displayName string
+		}{
+			{nil, "Alice's phone"},
+			{nil, "Element Web"},
+			{nil, ""},
+			{&deviceID, deviceID},
+		} {
+			displayName := tc.displayName
+			if _, err := db.CreateDevice(ctx, "alice", tc.deviceID, util.RandomString(16), &displayName, "127.0.0.1", ""); err != nil {
+				t.Fatalf("CreateDevice returned %s", err)
+			}
+		}
+		if _, err := db.CreateDevice(ctx, "alice", nil, util.RandomString(16), nil, "127.0.0.1", ""); err != nil {
+			t.Fatalf("CreateDevice returned %s", err)
+		}
+
+		named, total, err := db.DevicesWithCustomNames(ctx)
+		if err != nil {
+			t.Fatalf("DevicesWithCustomNames returned %s", err)
+		}
+		if named != 2 || total != 5 {
+			t.Fatalf("expected 2 of 5 devices to be named, got %d of %d", named, total)
+		}
+	})
+}
+
+func TestActiveIPs(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		connStr, close := test.PrepareDBConnectionString(t, dbType)
+		defer close()
+		db := mustOpenDatabase(t, connStr, nil, nil)
+		ctx := context.Background()
+		day := 24 * time.Hour
+
+		for _, localpart := range []string{"alice", "bob", "charlie", "dave"} {
+			mustCreateAccount(t, db, localpart, api.AccountTypeUser)
+		}
+		// alice and bob share an IP address, charlie uses two devices from
+		// different IPs, and dave's devices haven't been used recently
+		for _, device := range []struct {
+			localpart string
+			ip        string
+			lastSeen  time.Duration
+		}{
+			{"alice", "10.0.0.1", 0},
+			{"bob", "10.0.0.1", 0},
+			{"charlie", "10.0.0.2", 0},
+			{"charlie", "10.0.0.3", 3 * day},
+			{"dave", "10.0.0.4", 10 * day},
+			{"dave", "10.0.0.5", 45 * day},
+		} {
+			accessToken := util.RandomString(16)
+			if _, err := db.CreateDevice(ctx, device.localpart, nil, accessToken, nil, device.ip, ""); err != nil {
+				t.Fatalf("CreateDevice returned %s", err)
+			}
+			mustBackdateDevice(t, connStr, "last_seen_ts", accessToken, device.lastSeen)
+		}
+
+		daily, err := db.DailyActiveIPs(ctx)
+		if err != nil {
+			t.Fatalf("DailyActiveIPs returned %s", err)
+		}
+		monthly, err := db.MonthlyActiveIPs(ctx)
+		if err != nil {
+			t.Fatalf("MonthlyActiveIPs returned %s", err)
+		}
+		if daily != 2 || monthly != 4 {
+			t.Fatalf("expected 2 daily and 4 monthly active IPs, got %d and %d", daily, monthly)
+		}
+	})
+}
+
+func TestAvgDevicesAddedPerMonth(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		connStr, close := test.PrepareDBConnectionString(t, dbType)
+		defer close()
+		db := mustOpenDatabase(t, connStr, nil, nil)
+		ctx := context.Background()
+		day := 24 * time.Hour
+
+		mustCreateAccount(t, db, "alice", api.AccountTypeUser)
+		mustCreateAccount(t, db, "bob", api.AccountTypeUser)
+		mustCreateAccount(t, db, "guest", api.AccountTypeGuest)
+		// devices added over the past four months, the guest's isn't counted
+		for _, device := range []struct {
+			localpart  string
+			createdAgo time.Duration
+		}{
+			{"alice", 0},
+			{"alice", 20 * day},
+			{"bob", 45 * day},
+			{"bob", 80 * day},
+			{"alice", 100 * day},
+			{"guest", 0},
+		} {
+			accessToken := util.RandomString(16)
+			if _, err := db.CreateDevice(ctx, device.localpart, nil, accessToken, nil, "127.0.0.1", ""); err != nil {
+				t.Fatalf("CreateDevice returned %s", err)
+			}
+			mustBackdateDevice(t, connStr, "created_ts", accessToken, device.createdAgo)
+		}
+
+		// the devices added over the months, per user per month
+		for months, want := range map[int]float64{
+			1: 2.0 / 2 / 1,
+			2: 3.0 / 2 / 2,
+			3: 4.0 / 2 / 3,
+			4: 5.0 / 2 / 4,
+			6: 5.0 / 2 / 6,
+		} {
+			got, err := db.AvgDevicesAddedPerMonth(ctx, months)
+			if err != nil {
+				t.Fatalf("AvgDevicesAddedPerMonth returned %s", err)
+			}
+			if got != want {
+				t.Errorf("expected %v devices added per user per month over %d months, got %v", want, months, got)
+			}
+		}
+		if _, err := db.AvgDevicesAddedPerMonth(ctx, 0); err == nil {
+			t.Fatalf("expected AvgDevicesAddedPerMonth to fail for zero months")
+		}
+	})
+}
+
+func TestRegistrationsByFlow(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+		ctx := context.Background()
+
+		if _, err := db.CreateAccount(ctx, "before", "", "", api.AccountTypeUser, "m.login.dummy"); err != nil {
+			t.Fatalf("CreateAccount returned %s", err)
+		}
+		// created timestamps have millisecond resolution
+		time.Sleep(5 * time.Millisecond)
+		from := time.Now()
+
+		for localpart, flow := range map[string]string{
+			"alice":   "m.login.dummy",
+			"bob":     "m.login.dummy",
+			"charlie": "m.login.recaptcha,m.login.dummy",
+			"dave":    "",
+		} {
+			if _, err := db.CreateAccount(ctx, localpart, "", "", api.AccountTypeUser, flow); err != nil {
+				t.Fatalf("CreateAccount returned %s", err)
+			}
+		}
+		mustCreateAccount(t, db, "", api.AccountTypeGuest)
+
+		got, err := db.RegistrationsByFlow(ctx, from, time.Now().Add(time.Second))
+		if err != nil {
+			t.Fatalf("RegistrationsByFlow returned %s", err)
+		}
+		want := map[string]int64{
+			"m.login.dummy":                   2,
+			"m.login.recaptcha,m.login.dummy": 1,
+			"unknown":                         1,
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestAvgThreePIDsPerAccount(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+		ctx := context.Background()
+
+		for _, localpart := range []string{"alice", "bob", "charlie", "dave"} {
+			mustCreateAccount(t, db, localpart, api.AccountTypeUser)
+		}
+		// alice has no 3PIDs, bob has one, charlie has two and dave's account is deactivated
+		for _, threePID := range []struct {
+			localpart string
+			address   string
+			medium    string
+		}{
+			{"bob", "bob@example.com", "email"},
+			{"charlie", "charlie@example.com", "email"},
+			{"charlie", "+441234567890", "msisdn"},
+			{"dave", "dave@example.com", "email"},
+		} {
+			if err := db.SaveThreePIDAssociation(ctx, threePID.address, threePID.localpart, threePID.medium); err != nil {
+				t.Fatalf("SaveThreePIDAssociation returned %s", err)
+			}
+		}
+		if err := db.DeactivateAccount(ctx, "dave"); err != nil {
+			t.Fatalf("DeactivateAccount returned %s", err)
+		}
+
+		got, err := db.AvgThreePIDsPerAccount(ctx)
+		if err != nil {
+			t.Fatalf("AvgThreePIDsPerAccount returned %s", err)
+		}
+		if got != 1 {
+			t.Fatalf("expected an average of 1 3PID per account, got %v", got)
+		}
+	})
+}
+
+func TestThreePIDsByMedium(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+		ctx := context.Background()
+
+		for _, localpart := range []string{"alice", "bob", "charlie", "dave"} {
+			mustCreateAccount(t, db, localpart, api.AccountTypeUser)
+		}
+		// alice has no 3PIDs, bob has two email addresses, charlie has an email
+		// address and a phone number and dave's account is deactivated
+		for _, threePID := range []struct {
+			localpart string
+			address   string
+			medium    string
+		}{
+			{"bob", "bob@example.com", "email"},
+			{"bob", "bob@example.org", "email"},
+			{"charlie", "charlie@example.com", "email"},
+			{"charlie", "+441234567890", "msisdn"},
+			{"dave", "+441234567891", "msisdn"},
+		} {
+			if err := db.SaveThreePIDAssociation(ctx, threePID.address, threePID.localpart, threePID.medium); err != nil {
+				t.Fatalf("SaveThreePIDAssociation returned %s", err)
+			}
+		}
+		if err := db.DeactivateAccount(ctx, "dave"); err != nil {
+			t.Fatalf("DeactivateAccount returned %s", err)
+		}
+
+		got, err := db.ThreePIDsByMedium(ctx)
+		if err != nil {
+			t.Fatalf("ThreePIDsByMedium returned %s", err)
+		}
+		want := map[string]int64{"email": 2, "msisdn": 1}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestAppserviceCount(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+		ctx := context.Background()
+
+		mustCreateAccount(t, db, "alice", api.AccountTypeUser)
+		for localpart, appserviceID := range map[string]string{
+			"irc_bot":     "irc",
+			"irc_alice":   "irc",
+			"slack_bot":   "slack",
+			"discord_bot": "discord",
+		} {
+			if _, err := db.CreateAccount(ctx, localpart, "", appserviceID, api.AccountTypeAppService, ""); err != nil {
+				t.Fatalf("failed to create account %q: %s", localpart, err)
+			}
+		}
+		// the discord bridge's only user is deactivated
+		if err := db.DeactivateAccount(ctx, "discord_bot"); err != nil {
+			t.Fatalf("DeactivateAccount returned %s", err)
+		}
+
+		count, err := db.AppserviceCount(ctx)
+		if err != nil {
+			t.Fatalf("AppserviceCount returned %s", err)
+		}
+		if count != 2 {
+			t.Fatalf("expected 2 application services, got %d", count)
+		}
+		got, err := db.AppserviceUserCounts(ctx)
+		if err != nil {
+			t.Fatalf("AppserviceUserCounts returned %s", err)
+		}
+		want := map[string]int64{"irc": 2, "slack": 1}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestAvgUserAgentsPerUser(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+		ctx := context.Background()
+
+		// alice runs three different clients, bob runs the same client on two
+		// devices and charlie has no devices
+		for localpart, userAgents := range map[string][]string{
+			"alice":   {"Element/1.11.1", "nheko/v0.9.2", "FluffyChat/1.3.0", "Element/1.11.1"},
+			"bob":     {"Element/1.11.1", "Element/1.11.1"},
+			"charlie": {},
+		} {
+			mustCreateAccount(t, db, localpart, api.AccountTypeUser)
+			for _, userAgent := range userAgents {
+				if _, err := db.CreateDevice(ctx, localpart, nil, util.RandomString(16), nil, "127.0.0.1", userAgent); err != nil {
+					t.Fatalf("CreateDevice returned %s", err)
+				}
+			}
+		}
+
+		got, err := db.AvgUserAgentsPerUser(ctx)
+		if err != nil {
+			t.Fatalf("AvgUserAgentsPerUser returned %s", err)
+		}
+		if got != 2 {
+			t.Fatalf("expected an average of 2 user agents per user, got %v", got)
+		}
+	})
+}
+
+func TestNotificationOptInStats(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+		ctx := context.Background()
+
+		for _, localpart := range []string{"alice", "bob", "charlie", "dave"} {
+			mustCreateAccount(t, db, localpart, api.AccountTypeUser)
+		}
+		mustCreateAccount(t, db, "guest", api.AccountTypeGuest)
+		// alice has pushers on two devices, dave's pusher is ignored as they
+		// deactivated their account and the guest isn't counted at all
+		for _, localpart := range []string{"alice", "alice", "dave", "guest"} {
+			if err := db.UpsertPusher(ctx, api.Pusher{
+				PushKey: util.RandomString(16),
+				Kind:    api.HTTPKind,
+				AppID:   "im.vector.app",
+				Data:    map[string]interface{}{"url": "https://push.example.com"},
+			}, localpart); err != nil {
+				t.Fatalf("UpsertPusher returned %s", err)
+			}
+		}
+		if err := db.DeactivateAccount(ctx, "dave"); err != nil {
+			t.Fatalf("DeactivateAccount returned %s", err)
+		}
+
+		withPushers, withoutPushers, err := db.NotificationOptInStats(ctx)
+		if err != nil {
+			t.Fatalf("NotificationOptInStats returned %s", err)
+		}
+		if withPushers != 1 || withoutPushers != 2 {
+			t.Fatalf("expected 1 user with and 2 without pushers, got %d and %d", withPushers, withoutPushers)
+		}
+	})
+}
+
+func TestNotificationReadRatio(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+		ctx := context.Background()
+
+		// alice read the first two notifications in the room, bob read none
+		roomID := "!room:localhost"
+		for pos, localpart := range []string{"alice", "alice", "alice", "bob", "bob"} {
+			if err := db.InsertNotification(ctx, localpart, util.RandomString(16), int64(pos+1), nil, &api.Notification{
+				Event:  gomatrixserverlib.ClientEvent{Content: gomatrixserverlib.RawJSON("{}")},
+				RoomID: roomID,
+				TS:     gomatrixserverlib.AsTimestamp(time.Now()),
+			}); err != nil {
+				t.Fatalf("InsertNotification returned %s", err)
+			}
+		}
+		if _, err := db.SetNotificationsRead(ctx, "alice", roomID, 2, true); err != nil {
+			t.Fatalf("SetNotificationsRead returned %s", err)
+		}
+
+		read, unread, err := db.NotificationReadRatio(ctx)
+		if err != nil {
+			t.Fatalf("NotificationReadRatio returned %s", err)
+		}
+		if read != 2 || unread != 3 {
+			t.Fatalf("expected 2 read and 3 unread notifications, got %d and %d", read, unread)
+		}
+	})
+}
+
+func TestRegistrationVelocity(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+		ctx := context.Background()
+
+		for i := 0; i < 5; i++ {
+			mustCreateAccount(t, db, fmt.Sprintf("spammer%d", i), api.AccountTypeUser)
+		}
+		mustCreateAccount(t, db, "", api.AccountTypeGuest)
+
+		buckets, err := db.RegistrationVelocity(ctx, 3)
+		if err != nil {
+			t.Fatalf("RegistrationVelocity returned %s", err)
+		}
+		if len(buckets) != 3 {
+			t.Fatalf("expected 3 hourly buckets, got %d", len(buckets))
+		}
+		var total int64
+		for i, bucket := range buckets {
+			if i > 0 && bucket.Hour-buckets[i-1].Hour != int64(time.Hour/time.Millisecond) {
+				t.Fatalf("expected consecutive hours, got %v", buckets)
+			}
+			total += bucket.Count
+		}
+		if total != 5 {
+			t.Fatalf("expected 5 registrations, got %d", total)
+		}
+		if !api.RegistrationSpike(buckets, 4) {
+			t.Fatalf("expected a spike above 4 registrations an hour in %v", buckets)
+		}
+		if api.RegistrationSpike(buckets, 5) || api.RegistrationSpike(buckets, 0) {
+			t.Fatalf("expected no spike in %v", buckets)
+		}
+		if _, err = db.RegistrationVelocity(ctx, 0); err == nil {
+			t.Fatalf("expected an error for zero hours")
+		}
+	})
+}
+
+func TestUsersByAccountType(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+		ctx := context.Background()
+
+		mustCreateAccount(t, db, "alice", api.AccountTypeUser)
+		mustCreateAccount(t, db, "bob", api.AccountTypeUser)
+		mustCreateAccount(t, db, "charlie", api.AccountTypeUser)
+		mustCreateAccount(t, db, "admin", api.AccountTypeAdmin)
+		mustCreateAccount(t, db, "", api.AccountTypeGuest)
+		mustCreateAccount(t, db, "", api.AccountTypeGuest)
+		if _, err := db.CreateAccount(ctx, "irc_bot", "", "irc", api.AccountTypeAppService, ""); err != nil {
+			t.Fatalf("CreateAccount returned %s", err)
+		}
+		// charlie deactivated their account, so isn't counted
+		if err := db.DeactivateAccount(ctx, "charlie"); err != nil {
+			t.Fatalf("DeactivateAccount returned %s", err)
+		}
+
+		got, err := db.UsersByAccountType(ctx)
+		if err != nil {
+			t.Fatalf("UsersByAccountType returned %s", err)
+		}
+		want := map[string]int64{
+			"user":       2,
+			"guest":      2,
+			"admin":      1,
+			"appservice": 1,
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestRetentionCohort(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		connStr, close := test.PrepareDBConnectionString(t, dbType)
+		defer close()
+		dbOpts := &config.DatabaseOptions{ConnectionString: config.DataSource(connStr)}
+		db, err := storage.NewDatabase(dbOpts, "localhost", bcrypt.MinCost, config.DefaultOpenIDTokenLifetimeMS, api.DefaultLoginTokenLifetime*time.Millisecond, serverNoticesLocalpart, nil, nil)
+		if err != nil {
+			t.Fatalf("NewDatabase returned %s", err)
+		}
+		ctx := context.Background()
+		now := time.Now()
+		day := 24 * time.Hour
+
+		// alice is still around, bob stopped a day after registering,
+		// charlie never logged in and dave registered after the cohort
+		accounts := map[string]struct {
+			createdAgo  time.Duration
+			lastSeenAgo time.Duration
+		}{
+			"alice":   {8 * day, 0},
+			"bob":     {8 * day, 7 * day},
+			"charlie": {8 * day, -1},
+			"dave":    {2 * day, 0},
+		}
+		for localpart, account := range accounts {
+			mustCreateAccount(t, db, localpart, api.AccountTypeUser)
+			if account.lastSeenAgo < 0 {
+				continue
+			}
+			if _, err = db.CreateDevice(ctx, localpart, nil, util.RandomString(16), nil, "127.0.0.1", ""); err != nil {
+				t.Fatalf("CreateDevice returned %s", err)
+			}
+		}
+		mustCreateAccount(t, db, "", api.AccountTypeGuest)
+
+		// accounts and devices are always stored as created now, so backdate them
+		rawDB, err := sqlutil.Open(dbOpts)
+		if err != nil {
+			t.Fatalf("failed to open database: %s", err)
+		}
+		defer rawDB.Close() // nolint: errcheck
+		for localpart, account := range accounts {
+			if _, err = rawDB.ExecContext(ctx,
+				"UPDATE account_accounts SET created_ts = $1 WHERE localpart = $2",
+				gomatrixserverlib.AsTimestamp(now.Add(-account.createdAgo)), localpart,
+			); err != nil {
+				t.Fatalf("failed to backdate account: %s", err)
+			}
+			if _, err = rawDB.ExecContext(ctx,
+				"UPDATE device_devices SET last_seen_ts = $1 WHERE localpart = $2",
+				gomatrixserverlib.AsTimestamp(now.Add(-account.lastSeenAgo)), localpart,
+			); err != nil {
+				t.Fatalf("failed to backdate devices: %s", err)
+			}
+		}
+
+		cohort := [2]time.Time{now.Add(-10 * day), now.Add(-5 * day)}
+		registered, retained, err := db.RetentionCohort(ctx, cohort, 7)
+		if err != nil {
+			t.Fatalf("RetentionCohort returned %s", err)
+		}
+		if registered != 3 || retained != 1 {
+			t.Fatalf("expected 1 of 3 users retained, got %d of %d", retained, registered)
+		}
+		if _, _, err = db.RetentionCohort(ctx, cohort, 0); err == nil {
+			t.Fatalf("expected an error for zero retention days")
+		}
+	})
+}
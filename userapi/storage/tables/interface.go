@@ -31,12 +31,28 @@ type AccountDataTable interface {
 }
 
 type AccountsTable interface {
-	InsertAccount(ctx context.Context, txn *sql.Tx, localpart, hash, appserviceID string, accountType api.AccountType) (*api.Account, error)
+	InsertAccount(ctx context.Context, txn *sql.Tx, localpart, hash, appserviceID string, accountType api.AccountType, registrationFlow string) (*api.Account, error)
 	UpdatePassword(ctx context.Context, localpart, passwordHash string) (err error)
-	DeactivateAccount(ctx context.Context, localpart string) (err error)
+	// DeactivateAccount returns whether the account existed and wasn't already deactivated.
+	DeactivateAccount(ctx context.Context, txn *sql.Tx, localpart string) (bool, error)
 	SelectPasswordHash(ctx context.Context, localpart string) (hash string, err error)
 	SelectAccountByLocalpart(ctx context.Context, localpart string) (*api.Account, error)
 	SelectNewNumericLocalpart(ctx context.Context, txn *sql.Tx) (id int64, err error)
+	// SelectActiveAccountCount returns the number of user and admin accounts which are not deactivated.
+	SelectActiveAccountCount(ctx context.Context, txn *sql.Tx) (count int64, err error)
+	// SelectPasswordHashPrefixCounts returns the number of active accounts for each password hash prefix,
+	// which holds the hash algorithm and parameters. Passwordless accounts have an empty prefix.
+	SelectPasswordHashPrefixCounts(ctx context.Context, txn *sql.Tx) (map[string]int64, error)
+	// SelectRegistrationFlowCounts returns the number of non-guest accounts created in [fromMS, toMS) for each registration
+	// flow, with accounts whose flow isn't known counted as "".
+	SelectRegistrationFlowCounts(ctx context.Context, txn *sql.Tx, fromMS, toMS int64) (map[string]int64, error)
+	// SelectRegistrationCountsByHour returns the number of non-guest accounts created since fromMS for each hour since
+	// the epoch.
+	SelectRegistrationCountsByHour(ctx context.Context, txn *sql.Tx, fromMS int64) (map[int64]int64, error)
+	// SelectAppserviceUserCounts returns the number of active accounts registered by each application service.
+	SelectAppserviceUserCounts(ctx context.Context, txn *sql.Tx) (map[string]int64, error)
+	// SelectAccountTypeCounts returns the number of active accounts of each account type.
+	SelectAccountTypeCounts(ctx context.Context, txn *sql.Tx) (map[api.AccountType]int64, error)
 }
 
 type DevicesTable interface {
@@ -50,6 +66,26 @@ type DevicesTable interface {
 	SelectDevicesByLocalpart(ctx context.Context, txn *sql.Tx, localpart, exceptDeviceID string) ([]api.Device, error)
 	SelectDevicesByID(ctx context.Context, deviceIDs []string) ([]api.Device, error)
 	UpdateDeviceLastSeen(ctx context.Context, txn *sql.Tx, localpart, deviceID, ipAddr string) error
+	// SelectUserAgentsSince returns the user agents of all devices last seen at or after the given time.
+	SelectUserAgentsSince(ctx context.Context, txn *sql.Tx, lastSeenAfterMS int64) ([]string, error)
+	// SelectInactiveSincePasswordChangeCount returns the number of active accounts which changed their password
+	// and haven't used any device since.
+	SelectInactiveSincePasswordChangeCount(ctx context.Context, txn *sql.Tx) (count int64, err error)
+	// SelectDormantAccountCount returns the number of active accounts which have no device last seen at or after the
+	// given time.
+	SelectDormantAccountCount(ctx context.Context, txn *sql.Tx, lastSeenBeforeMS int64) (count int64, err error)
+	// SelectNamedDeviceCounts returns the number of devices with a custom display name and the total number of devices.
+	SelectNamedDeviceCounts(ctx context.Context, txn *sql.Tx) (named, total int64, err error)
+	// SelectDistinctIPCountSince returns the number of distinct IP addresses of devices last seen at or after the given time.
+	SelectDistinctIPCountSince(ctx context.Context, txn *sql.Tx, lastSeenAfterMS int64) (count int64, err error)
+	// SelectDevicesCreatedSinceCount returns the number of devices of active accounts created at or after the given time.
+	SelectDevicesCreatedSinceCount(ctx context.Context, txn *sql.Tx, createdAfterMS int64) (count int64, err error)
+	// SelectUserAgentDiversity returns the total of the number of distinct user agents of each user, and the number
+	// of users with at least one device with a user agent.
+	SelectUserAgentDiversity(ctx context.Context, txn *sql.Tx) (userAgents, users int64, err error)
+	// SelectRetentionCohortCounts returns the number of user and admin accounts created in [createdFromMS, createdToMS),
+	// and the number of those with a device last seen at least retainedAfterMS after the account was created.
+	SelectRetentionCohortCounts(ctx context.Context, txn *sql.Tx, createdFromMS, createdToMS, retainedAfterMS int64) (registered, retained int64, err error)
 }
 
 type KeyBackupTable interface {
@@ -67,6 +103,8 @@ type KeyBackupVersionTable interface {
 	UpdateKeyBackupETag(ctx context.Context, txn *sql.Tx, userID, version, etag string) error
 	DeleteKeyBackup(ctx context.Context, txn *sql.Tx, userID, version string) (bool, error)
 	SelectKeyBackup(ctx context.Context, txn *sql.Tx, userID, version string) (versionResult, algorithm string, authData json.RawMessage, etag string, deleted bool, err error)
+	// SelectUsersWithKeyBackupCount returns the number of active user and admin accounts with at least one backup version which isn't deleted.
+	SelectUsersWithKeyBackupCount(ctx context.Context, txn *sql.Tx) (count int64, err error)
 }
 
 type LoginTokenTable interface {
@@ -86,6 +124,7 @@ type ProfileTable interface {
 	SetAvatarURL(ctx context.Context, txn *sql.Tx, localpart string, avatarURL string) (err error)
 	SetDisplayName(ctx context.Context, txn *sql.Tx, localpart string, displayName string) (err error)
 	SelectProfilesBySearch(ctx context.Context, searchString string, limit int) ([]authtypes.Profile, error)
+	SelectStaleProfileCount(ctx context.Context, txn *sql.Tx) (int64, error)
 }
 
 type ThreePIDTable interface {
@@ -93,6 +132,10 @@ type ThreePIDTable interface {
 	SelectThreePIDsForLocalpart(ctx context.Context, localpart string) (threepids []authtypes.ThreePID, err error)
 	InsertThreePID(ctx context.Context, txn *sql.Tx, threepid, medium, localpart string) (err error)
 	DeleteThreePID(ctx context.Context, txn *sql.Tx, threepid string, medium string) (err error)
+	// SelectActiveAccountThreePIDCount returns the number of 3PIDs associated with active user and admin accounts.
+	SelectActiveAccountThreePIDCount(ctx context.Context, txn *sql.Tx) (count int64, err error)
+	// SelectThreePIDMediumCounts returns the number of active user and admin accounts with a 3PID of each medium.
+	SelectThreePIDMediumCounts(ctx context.Context, txn *sql.Tx) (map[string]int64, error)
 }
 
 type PusherTable interface {
@@ -100,6 +143,8 @@ type PusherTable interface {
 	SelectPushers(ctx context.Context, txn *sql.Tx, localpart string) ([]api.Pusher, error)
 	DeletePusher(ctx context.Context, txn *sql.Tx, appid, pushkey, localpart string) error
 	DeletePushers(ctx context.Context, txn *sql.Tx, appid, pushkey string) error
+	// SelectUsersWithPushersCount returns the number of active user and admin accounts with a pusher.
+	SelectUsersWithPushersCount(ctx context.Context, txn *sql.Tx) (int64, error)
 }
 
 type NotificationTable interface {
@@ -110,8 +155,22 @@ type NotificationTable interface {
 	Select(ctx context.Context, txn *sql.Tx, localpart string, fromID int64, limit int, filter NotificationFilter) ([]*api.Notification, int64, error)
 	SelectCount(ctx context.Context, txn *sql.Tx, localpart string, filter NotificationFilter) (int64, error)
 	SelectRoomCounts(ctx context.Context, txn *sql.Tx, localpart, roomID string) (total int64, highlight int64, _ error)
+	// SelectReadCounts returns the number of read and unread notifications across all users.
+	SelectReadCounts(ctx context.Context, txn *sql.Tx) (read, unread int64, err error)
 }
 
+type StatsCountersTable interface {
+	IncrementCounter(ctx context.Context, txn *sql.Tx, name string, delta int64) error
+	SelectCounters(ctx context.Context, txn *sql.Tx) (map[string]int64, error)
+}
+
+// Names of the running counters kept in the StatsCountersTable. Guest
+// accounts aren't counted as registrations.
+const (
+	StatsCounterRegistrations = "registrations"
+	StatsCounterDeactivations = "deactivations"
+)
+
 type NotificationFilter uint32
 
 const (
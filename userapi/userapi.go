@@ -30,6 +30,7 @@ import (
 	"github.com/matrix-org/dendrite/userapi/inthttp"
 	"github.com/matrix-org/dendrite/userapi/producers"
 	"github.com/matrix-org/dendrite/userapi/storage"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 )
 
@@ -58,6 +59,9 @@ func NewInternalAPI(
 		cfg.Matrix.JetStream.Prefixed(jetstream.OutputNotificationData),
 	)
 
+	registerCollectors(newDBStatsMetrics(db)...)
+	startRegistrationMetrics(base.Context(), db, cfg.RegistrationSpikeThreshold)
+
 	userAPI := &internal.UserInternalAPI{
 		DB:                   db,
 		SyncProducer:         syncProducer,
@@ -93,3 +97,23 @@ func NewInternalAPI(
 
 	return userAPI
 }
+
+// registerCollectors registers the collectors with the default registry. A
+// collector registered by an earlier NewInternalAPI call in the same process,
+// e.g. in tests, is replaced so that it doesn't keep reporting on a database
+// which is no longer used.
+func registerCollectors(collectors ...prometheus.Collector) {
+	for _, collector := range collectors {
+		err := prometheus.Register(collector)
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			if are.ExistingCollector == collector {
+				continue
+			}
+			prometheus.Unregister(are.ExistingCollector)
+			err = prometheus.Register(collector)
+		}
+		if err != nil {
+			logrus.WithError(err).Panic("failed to register user API metrics")
+		}
+	}
+}